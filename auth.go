@@ -0,0 +1,468 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// authKeyPrefix reserves the part of the key space auth state is kept in, so it's
+	// replicated, persisted and snapshotted together with the store. Keys with the
+	// prefix aren't accessible through the API.
+	authKeyPrefix  = "\x00auth/"
+	authEnabledKey = authKeyPrefix + "enabled"
+	authUserPrefix = authKeyPrefix + "user/"
+	authRolePrefix = authKeyPrefix + "role/"
+
+	// rootUser has to exist with rootRole before auth can be enabled
+	rootUser = "root"
+	// rootRole grants everything, it always exists and can't be changed
+	rootRole = "root"
+)
+
+var (
+	errAuthFailed           = errors.New("invalid user name or password")
+	errPermissionDenied     = errors.New("permission denied")
+	errUserExists           = errors.New("user already exists")
+	errUserNotFound         = errors.New("user not found")
+	errRoleExists           = errors.New("role already exists")
+	errRoleNotFound         = errors.New("role not found")
+	errRoleNotGranted       = errors.New("role not granted to the user")
+	errPermissionNotGranted = errors.New("permission not granted to the role")
+	errRootRequired         = errors.New("root user with root role is required while auth is enabled")
+	errRootRoleImmutable    = errors.New("root role can't be changed")
+)
+
+type authAction uint8
+
+// actions are ordered like AuthChange actions of the command protobuf
+const (
+	authEnable authAction = iota
+	authDisable
+	authUserAdd
+	authUserDelete
+	authUserChangePassword
+	authUserGrantRole
+	authUserRevokeRole
+	authRoleAdd
+	authRoleDelete
+	authRoleGrantPermission
+	authRoleRevokePermission
+)
+
+type permType uint8
+
+const (
+	permRead permType = iota
+	permWrite
+	permReadWrite
+)
+
+// permission grants the RPC if Method is set, access to keys with KeyPrefix otherwise.
+// Methods ending with /* grant all RPCs of the service.
+type permission struct {
+	Method    string   `json:",omitempty"`
+	KeyPrefix string   `json:",omitempty"`
+	Type      permType `json:",omitempty"`
+}
+
+// keyAccess is a range of keys [Start, End) accessed by a request, an empty end
+// means there is no upper bound
+type keyAccess struct {
+	Start string
+	End   string
+	Write bool
+}
+
+// authChange is a single change of users, roles or the auth switch
+type authChange struct {
+	Action       authAction
+	User         string
+	Role         string
+	PasswordHash []byte // set for authUserAdd and authUserChangePassword
+	Perm         permission
+}
+
+type authUser struct {
+	PasswordHash []byte
+	Roles        []string `json:",omitempty"`
+}
+
+type authRole struct {
+	Permissions []permission `json:",omitempty"`
+}
+
+// authState is the in-memory view of the auth keys of the store
+type authState struct {
+	enabled bool
+	users   map[string]*authUser
+	roles   map[string]*authRole
+}
+
+// passwordCache remembers the last password verified per user, bcrypt is too slow
+// to run on every request
+type passwordCache struct {
+	mu   sync.Mutex
+	sums map[string]passwordSum
+}
+
+type passwordSum struct {
+	hash []byte // password hash the password was verified against
+	sum  [sha256.Size]byte
+}
+
+func reservedKey(key string) bool {
+	return strings.HasPrefix(key, authKeyPrefix) || strings.HasPrefix(key, historyKeyPrefix)
+}
+
+func (u *authUser) hasRole(role string) bool {
+	for _, r := range u.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func (p permission) allowsMethod(method string) bool {
+	if p.Method == "" {
+		return false
+	}
+	if strings.HasSuffix(p.Method, "/*") {
+		return strings.HasPrefix(method, strings.TrimSuffix(p.Method, "*"))
+	}
+	return p.Method == method
+}
+
+// allowsKeys reports whether the whole range of keys is covered by the prefix
+func (p permission) allowsKeys(k keyAccess) bool {
+	if p.Method != "" || !strings.HasPrefix(k.Start, p.KeyPrefix) {
+		return false
+	}
+	if k.Write && p.Type == permRead || !k.Write && p.Type == permWrite {
+		return false
+	}
+	end := prefixEnd(p.KeyPrefix)
+	return end == "" || k.End != "" && k.End <= end
+}
+
+// hashPassword hashes the password to be replicated in an authChange.
+func hashPassword(password string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+}
+
+// ChangeAuth replicates the change of users, roles or the auth switch and blocks
+// until it has been applied. It returns the raft log index of the change.
+func (s *kvstore) ChangeAuth(ctx context.Context, change authChange) (uint64, error) {
+	result, err := s.propose(ctx, command{Op: opAuth, Auth: &change})
+	return result.index, err
+}
+
+// AuthEnabled reports whether requests have to be authenticated.
+func (s *kvstore) AuthEnabled() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.auth.enabled
+}
+
+// UserRoles returns the roles granted to the user.
+func (s *kvstore) UserRoles(name string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	u, ok := s.auth.users[name]
+	if !ok {
+		return nil, errUserNotFound
+	}
+	return append([]string(nil), u.Roles...), nil
+}
+
+// RolePermissions returns the permissions granted to the role.
+func (s *kvstore) RolePermissions(name string) ([]permission, error) {
+	if name == rootRole {
+		return []permission{{Method: "/*"}, {Type: permReadWrite}}, nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	r, ok := s.auth.roles[name]
+	if !ok {
+		return nil, errRoleNotFound
+	}
+	return append([]permission(nil), r.Permissions...), nil
+}
+
+// Authenticate checks the password of the user.
+func (s *kvstore) Authenticate(name, password string) error {
+	s.mu.RLock()
+	u, ok := s.auth.users[name]
+	var hash []byte
+	if ok {
+		hash = u.PasswordHash
+	}
+	s.mu.RUnlock()
+	if !ok {
+		return errAuthFailed
+	}
+
+	sum := sha256.Sum256([]byte(password))
+	if s.passwords.verified(name, hash, sum) {
+		return nil
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil {
+		return errAuthFailed
+	}
+	s.passwords.add(name, hash, sum)
+	return nil
+}
+
+// Authorize checks roles of the user grant the method and access to all the keys.
+// A single permission has to cover each range of keys.
+func (s *kvstore) Authorize(name, method string, keys []keyAccess) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	u, ok := s.auth.users[name]
+	if !ok {
+		return errPermissionDenied
+	}
+	if u.hasRole(rootRole) {
+		return nil
+	}
+	if !s.auth.granted(u, func(p permission) bool { return p.allowsMethod(method) }) {
+		return errPermissionDenied
+	}
+	for _, k := range keys {
+		if !s.auth.granted(u, func(p permission) bool { return p.allowsKeys(k) }) {
+			return errPermissionDenied
+		}
+	}
+	return nil
+}
+
+// granted reports whether any permission of the user's roles is allowed by fn
+func (a *authState) granted(u *authUser, fn func(p permission) bool) bool {
+	for _, name := range u.Roles {
+		r, ok := a.roles[name]
+		if !ok {
+			continue
+		}
+		for _, p := range r.Permissions {
+			if fn(p) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// applyAuth applies the auth change and writes the changed user or role to the
+// transaction. It has to be called with the store lock held.
+func (s *kvstore) applyAuth(tx backendTx, index uint64, c *authChange) error {
+	if c == nil {
+		log.Printf("raftexample: ignoring auth change without body at index %d", index)
+		return nil
+	}
+	a := &s.auth
+	u, userOK := a.users[c.User]
+	r, roleOK := a.roles[c.Role]
+	if c.Role == rootRole {
+		roleOK = true
+	}
+
+	switch c.Action {
+	case authEnable:
+		if root, ok := a.users[rootUser]; !ok || !root.hasRole(rootRole) {
+			return errRootRequired
+		}
+		a.enabled = true
+		tx.put(authEnabledKey, "true", keyMeta{ModIndex: index})
+		return nil
+	case authDisable:
+		if a.enabled {
+			a.enabled = false
+			tx.delete(authEnabledKey)
+		}
+		return nil
+	case authUserAdd:
+		if userOK {
+			return errUserExists
+		}
+		if err := checkKeySize(authUserPrefix+c.User, 0); err != nil {
+			return err
+		}
+		u = &authUser{PasswordHash: c.PasswordHash}
+	case authUserDelete:
+		if !userOK {
+			return errUserNotFound
+		}
+		if a.enabled && c.User == rootUser {
+			return errRootRequired
+		}
+		delete(a.users, c.User)
+		tx.delete(authUserPrefix + c.User)
+		return nil
+	case authUserChangePassword:
+		if !userOK {
+			return errUserNotFound
+		}
+		u.PasswordHash = c.PasswordHash
+	case authUserGrantRole:
+		if !userOK {
+			return errUserNotFound
+		}
+		if !roleOK {
+			return errRoleNotFound
+		}
+		if u.hasRole(c.Role) {
+			return nil
+		}
+		u.Roles = append(u.Roles, c.Role)
+	case authUserRevokeRole:
+		if !userOK {
+			return errUserNotFound
+		}
+		if !u.hasRole(c.Role) {
+			return errRoleNotGranted
+		}
+		if a.enabled && c.User == rootUser && c.Role == rootRole {
+			return errRootRequired
+		}
+		u.Roles = withoutRole(u.Roles, c.Role)
+	case authRoleAdd:
+		if roleOK {
+			return errRoleExists
+		}
+		if err := checkKeySize(authRolePrefix+c.Role, 0); err != nil {
+			return err
+		}
+		r = &authRole{}
+	case authRoleDelete:
+		if c.Role == rootRole {
+			return errRootRoleImmutable
+		}
+		if !roleOK {
+			return errRoleNotFound
+		}
+		// users lose the role together with it
+		for name, u := range a.users {
+			if u.hasRole(c.Role) {
+				u.Roles = withoutRole(u.Roles, c.Role)
+				putAuthValue(tx, index, authUserPrefix+name, u)
+			}
+		}
+		delete(a.roles, c.Role)
+		tx.delete(authRolePrefix + c.Role)
+		return nil
+	case authRoleGrantPermission, authRoleRevokePermission:
+		if c.Role == rootRole {
+			return errRootRoleImmutable
+		}
+		if !roleOK {
+			return errRoleNotFound
+		}
+		perms := withoutPermission(r.Permissions, c.Perm)
+		if c.Action == authRoleGrantPermission {
+			perms = append(perms, c.Perm)
+		} else if len(perms) == len(r.Permissions) {
+			return errPermissionNotGranted
+		}
+		r.Permissions = perms
+	default:
+		log.Printf("raftexample: ignoring unknown auth action %d at index %d", c.Action, index)
+		return nil
+	}
+
+	if c.Action <= authUserRevokeRole {
+		a.users[c.User] = u
+		putAuthValue(tx, index, authUserPrefix+c.User, u)
+	} else {
+		a.roles[c.Role] = r
+		putAuthValue(tx, index, authRolePrefix+c.Role, r)
+	}
+	return nil
+}
+
+// putAuthValue writes the JSON encoded user or role under the key
+func putAuthValue(tx backendTx, index uint64, key string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		// users and roles consist of strings and byte slices only
+		log.Panicf("raftexample: cannot encode %q (%v)", key, err)
+	}
+	tx.put(key, string(data), keyMeta{ModIndex: index})
+}
+
+func withoutRole(roles []string, role string) []string {
+	kept := make([]string, 0, len(roles))
+	for _, r := range roles {
+		if r != role {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
+
+func withoutPermission(perms []permission, perm permission) []permission {
+	kept := make([]permission, 0, len(perms)+1)
+	for _, p := range perms {
+		// a permission of the same target gets replaced by a grant
+		if p.Method != perm.Method || p.KeyPrefix != perm.KeyPrefix {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
+
+// loadAuth reads the auth state kept by the backend.
+func (s *kvstore) loadAuth() error {
+	a := authState{users: make(map[string]*authUser), roles: make(map[string]*authRole)}
+	err := s.backend.view(func(tx backendTx) error {
+		var err error
+		tx.ascend(authKeyPrefix, prefixEnd(authKeyPrefix), func(key, val string, meta keyMeta) bool {
+			switch {
+			case key == authEnabledKey:
+				a.enabled = true
+			case strings.HasPrefix(key, authUserPrefix):
+				u := &authUser{}
+				err = json.Unmarshal([]byte(val), u)
+				a.users[strings.TrimPrefix(key, authUserPrefix)] = u
+			case strings.HasPrefix(key, authRolePrefix):
+				r := &authRole{}
+				err = json.Unmarshal([]byte(val), r)
+				a.roles[strings.TrimPrefix(key, authRolePrefix)] = r
+			}
+			return err == nil
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.auth = a
+	return nil
+}
+
+func (c *passwordCache) verified(name string, hash []byte, sum [sha256.Size]byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.sums[name]
+	return ok && bytes.Equal(v.hash, hash) && subtle.ConstantTimeCompare(v.sum[:], sum[:]) == 1
+}
+
+func (c *passwordCache) add(name string, hash []byte, sum [sha256.Size]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sums == nil {
+		c.sums = make(map[string]passwordSum)
+	}
+	c.sums[name] = passwordSum{hash: hash, sum: sum}
+}
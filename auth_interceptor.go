@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	apiV1 "github/m-wrona/raft-go/model/api/v1"
+	apiV2 "github/m-wrona/raft-go/model/api/v2"
+)
+
+const (
+	// authHeader carries credentials of the user as "Basic base64(name:password)"
+	authHeader = "authorization"
+	// healthService is served without credentials so probes keep working once auth is enabled
+	healthService = "/grpc.health.v1.Health/"
+)
+
+// authorizer checks requests against users and roles of the store once auth is enabled
+type authorizer struct {
+	log   *zap.Logger
+	store *kvstore
+}
+
+func newAuthorizer(log *zap.Logger, store *kvstore) *authorizer {
+	return &authorizer{
+		log:   log.With(zap.String("component", "authorizer")),
+		store: store,
+	}
+}
+
+// UnaryInterceptor rejects requests of users whose roles don't grant the RPC or the
+// keys it accesses. It runs before forwarding, the leader checks forwarded requests
+// again.
+func (a *authorizer) UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := a.check(ctx, info.FullMethod, req); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// StreamInterceptor checks streaming RPCs like UnaryInterceptor. Requests are received
+// by the handler, so keys are checked once a request arrives.
+func (a *authorizer) StreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := a.check(ss.Context(), info.FullMethod, nil); err != nil {
+		return err
+	}
+	return handler(srv, &authServerStream{ServerStream: ss, auth: a, method: info.FullMethod})
+}
+
+// check authenticates the caller and checks its roles grant the method and the keys
+// the request accesses. Requests pass unchecked while auth is disabled.
+func (a *authorizer) check(ctx context.Context, method string, req interface{}) error {
+	if strings.HasPrefix(method, healthService) || !a.store.AuthEnabled() {
+		return nil
+	}
+	name, password, ok := credentialsFrom(ctx)
+	if !ok {
+		return status.Error(codes.Unauthenticated, "credentials required")
+	}
+	if err := a.store.Authenticate(name, password); err != nil {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+	if err := a.store.Authorize(name, method, a.keysAccessedBy(req)); err != nil {
+		a.log.Debug("Request denied", zap.String("user", name), zap.String("method", method))
+		return status.Errorf(codes.PermissionDenied, "user %q is not allowed to call %s on the keys", name, method)
+	}
+	return nil
+}
+
+// credentialsFrom returns the user name and password of the request metadata
+func credentialsFrom(ctx context.Context) (string, string, bool) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(authHeader)
+	if len(values) == 0 || !strings.HasPrefix(values[0], "Basic ") {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(values[0], "Basic "))
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(decoded), ":")
+}
+
+// keysAccessedBy returns the keys read or written by the request. Requests using a
+// lease write the keys attached to it, as revoking the lease deletes them and putting
+// a key with it makes the key expire with them.
+func (a *authorizer) keysAccessedBy(req interface{}) []keyAccess {
+	switch r := req.(type) {
+	case *apiV1.SetValueRequest:
+		return []keyAccess{singleKey(valueKey, true)}
+	case *apiV1.GetValueRequest:
+		return []keyAccess{singleKey(valueKey, false)}
+	case *apiV2.PutRequest:
+		return append(a.leaseKeysAccess(r.Lease), singleKey(r.Key, true))
+	case *apiV2.GetRequest:
+		return []keyAccess{singleKey(r.Key, false)}
+	case *apiV2.DeleteRequest:
+		return []keyAccess{singleKey(r.Key, true)}
+	case *apiV2.RangeRequest:
+		if r.Prefix != "" {
+			return []keyAccess{{Start: r.Prefix, End: prefixEnd(r.Prefix)}}
+		}
+		return []keyAccess{{Start: r.StartKey, End: r.EndKey}}
+	case *apiV2.WatchRequest:
+		if r.Prefix {
+			return []keyAccess{{Start: r.Key, End: prefixEnd(r.Key)}}
+		}
+		return []keyAccess{singleKey(r.Key, false)}
+	case *apiV2.TxnRequest:
+		keys := make([]keyAccess, 0, len(r.Compare)+len(r.Success)+len(r.Failure))
+		for _, c := range r.Compare {
+			keys = append(keys, singleKey(c.Key, false))
+		}
+		for _, ops := range [][]*apiV2.RequestOp{r.Success, r.Failure} {
+			for _, o := range ops {
+				if o.GetPut() != nil {
+					keys = append(keys, singleKey(o.GetPut().Key, true))
+				} else {
+					keys = append(keys, singleKey(o.GetDelete().GetKey(), true))
+				}
+			}
+		}
+		return keys
+	case *apiV2.LeaseKeepAliveRequest:
+		return a.leaseKeysAccess(r.Id)
+	case *apiV2.LeaseRevokeRequest:
+		return a.leaseKeysAccess(r.Id)
+	default:
+		return nil
+	}
+}
+
+// leaseKeysAccess returns write access to the keys attached to the lease
+func (a *authorizer) leaseKeysAccess(id int64) []keyAccess {
+	if id == 0 {
+		return nil
+	}
+	keys := a.store.LeaseKeys(id)
+	access := make([]keyAccess, 0, len(keys)+1)
+	for _, key := range keys {
+		access = append(access, singleKey(key, true))
+	}
+	return access
+}
+
+func singleKey(key string, write bool) keyAccess {
+	return keyAccess{Start: key, End: key + "\x00", Write: write}
+}
+
+// authServerStream checks requests received by streaming RPCs
+type authServerStream struct {
+	grpc.ServerStream
+	auth   *authorizer
+	method string
+}
+
+func (s *authServerStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	return s.auth.check(s.Context(), s.method, m)
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/google/btree"
+)
+
+// backend keeps the key space and leases of kvstore together with the raft log
+// index they've been applied up to
+type backend interface {
+	// view runs fn with a read only transaction
+	view(fn func(tx backendTx) error) error
+	// update runs fn with a read-write transaction and commits its changes together
+	// with the applied index
+	update(index uint64, fn func(tx backendTx) error) error
+	// reset replaces the whole content with the one written by fn at the applied
+	// index, nothing changes if fn fails
+	reset(index uint64, fn func(tx backendTx) error) error
+	// appliedIndex returns the raft log index updates have been applied up to
+	appliedIndex() uint64
+
+	// snapshot captures the content and returns a function writing it in the snapshot
+	// format native to the backend. Updates made before the function is called don't
+	// change what it writes, it has to be called exactly once.
+	snapshot() (func(w io.Writer) error, error)
+	// restoreDB replaces the content with a database file snapshot taken at the index
+	restoreDB(r io.Reader, index uint64) error
+
+	close() error
+}
+
+// openBackend opens the backend of the kind, bolt backends keep the store in the
+// database file at the path. Memory backends use its directory for restoring.
+func openBackend(kind, path string) (backend, error) {
+	switch kind {
+	case "memory":
+		return newMemoryBackend(filepath.Dir(path)), nil
+	case "bolt":
+		b, err := openBoltBackend(path)
+		if err != nil {
+			return nil, err
+		}
+		return b, nil
+	default:
+		return nil, fmt.Errorf("unknown backend %q", kind)
+	}
+}
+
+// backendTx reads and writes the content of a backend
+type backendTx interface {
+	get(key string) (string, keyMeta, bool)
+	// ascend calls fn for keys in [start, end) in ascending key order until it returns
+	// false. An empty end means there is no upper bound.
+	ascend(start, end string, fn func(key, val string, meta keyMeta) bool)
+	// put stores the pair and attaches it to the lease in its metadata
+	put(key, val string, meta keyMeta)
+	delete(key string)
+
+	forEachLease(fn func(id, ttl int64))
+	putLease(id, ttl int64)
+	// deleteLease removes the lease, keys attached to it have to be deleted first
+	deleteLease(id int64)
+	// leaseKeys returns the keys attached to the lease
+	leaseKeys(id int64) []string
+}
+
+// memBackend keeps the content in memory, it's rebuilt from the snapshot and the
+// raft log on every start
+type memBackend struct {
+	mu       sync.RWMutex
+	items    *btree.BTreeG[memItem] // current committed pairs ordered by key
+	ttls     map[int64]int64        // TTLs of granted leases by ID
+	attached map[int64]map[string]struct{}
+	index    uint64
+	dir      string // database file snapshots are unpacked to while restoring
+}
+
+// memItem is a pair kept by memBackend together with its metadata
+type memItem struct {
+	key  string
+	val  string
+	meta keyMeta
+}
+
+// newMemoryBackend returns an empty backend restoring database file snapshots through
+// the directory, they can be large so it should be on disk rather than the default
+// temporary directory used if it's empty.
+func newMemoryBackend(dir string) *memBackend {
+	return &memBackend{
+		dir:      dir,
+		items:    newItemIndex(),
+		ttls:     make(map[int64]int64),
+		attached: make(map[int64]map[string]struct{}),
+	}
+}
+
+func (b *memBackend) view(fn func(tx backendTx) error) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return fn(b)
+}
+
+func (b *memBackend) update(index uint64, fn func(tx backendTx) error) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := fn(b); err != nil {
+		return err
+	}
+	b.index = index
+	return nil
+}
+
+func (b *memBackend) reset(index uint64, fn func(tx backendTx) error) error {
+	fresh := newMemoryBackend(b.dir)
+	if err := fn(fresh); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.items = fresh.items
+	b.ttls, b.attached = fresh.ttls, fresh.attached
+	b.index = index
+	return nil
+}
+
+func (b *memBackend) appliedIndex() uint64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.index
+}
+
+// snapshot takes a view of the content under the lock, so updates only wait for the
+// pairs to be cloned rather than for the snapshot to be written. Cloning changes the
+// tree, so the write lock is taken.
+func (b *memBackend) snapshot() (func(w io.Writer) error, error) {
+	b.mu.Lock()
+	view := b.snapshotView()
+	b.mu.Unlock()
+	return view.writeSnapshot, nil
+}
+
+// restoreDB copies a database file snapshot, written by nodes keeping their store
+// on disk, into memory. The file is unpacked to the directory of the backend first.
+func (b *memBackend) restoreDB(r io.Reader, index uint64) error {
+	f, err := os.CreateTemp(b.dir, "raftexample-*.db.restore")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	err = readDBSnapshot(r, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	db, err := openBoltBackend(f.Name())
+	if err != nil {
+		return err
+	}
+	defer db.close()
+	return db.view(func(src backendTx) error {
+		return b.reset(index, func(dst backendTx) error {
+			copyBackend(dst, src)
+			return nil
+		})
+	})
+}
+
+func (b *memBackend) close() error {
+	return nil
+}
+
+func (b *memBackend) get(key string) (string, keyMeta, bool) {
+	item, ok := b.items.Get(memItem{key: key})
+	return item.val, item.meta, ok
+}
+
+func (b *memBackend) ascend(start, end string, fn func(key, val string, meta keyMeta) bool) {
+	iter := func(item memItem) bool {
+		return fn(item.key, item.val, item.meta)
+	}
+	if end == "" {
+		b.items.AscendGreaterOrEqual(memItem{key: start}, iter)
+	} else {
+		b.items.AscendRange(memItem{key: start}, memItem{key: end}, iter)
+	}
+}
+
+func (b *memBackend) put(key, val string, meta keyMeta) {
+	b.detach(key)
+	if meta.Lease != 0 {
+		if b.attached[meta.Lease] == nil {
+			b.attached[meta.Lease] = make(map[string]struct{})
+		}
+		b.attached[meta.Lease][key] = struct{}{}
+	}
+	b.items.ReplaceOrInsert(memItem{key: key, val: val, meta: meta})
+}
+
+func (b *memBackend) delete(key string) {
+	b.detach(key)
+	b.items.Delete(memItem{key: key})
+}
+
+// detach removes the key from the lease it's attached to
+func (b *memBackend) detach(key string) {
+	if item, ok := b.items.Get(memItem{key: key}); ok && item.meta.Lease != 0 {
+		delete(b.attached[item.meta.Lease], key)
+	}
+}
+
+func (b *memBackend) forEachLease(fn func(id, ttl int64)) {
+	for id, ttl := range b.ttls {
+		fn(id, ttl)
+	}
+}
+
+func (b *memBackend) putLease(id, ttl int64) {
+	b.ttls[id] = ttl
+}
+
+func (b *memBackend) deleteLease(id int64) {
+	delete(b.ttls, id)
+	delete(b.attached, id)
+}
+
+func (b *memBackend) leaseKeys(id int64) []string {
+	keys := make([]string, 0, len(b.attached[id]))
+	for key := range b.attached[id] {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// copyBackend writes leases and pairs of src to dst
+func copyBackend(dst, src backendTx) {
+	src.forEachLease(dst.putLease)
+	src.ascend("", "", func(key, val string, meta keyMeta) bool {
+		dst.put(key, val, meta)
+		return true
+	})
+}
+
+func newItemIndex() *btree.BTreeG[memItem] {
+	return btree.NewG(32, func(a, b memItem) bool { return a.key < b.key })
+}
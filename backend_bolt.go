@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Database file snapshots are written as a header followed by the bbolt file as it
+// is on disk and a trailing checksum:
+//
+//	header:   magic "RGDB" | version uint32 | applied index uint64 | file size uint64
+//	trailer:  CRC-32C of all preceding bytes uint32
+//
+// Fixed size integers are big endian.
+const (
+	dbSnapshotMagic   = "RGDB"
+	dbSnapshotVersion = 1
+
+	// maxKeySize is the longest key the store accepts, it's bound by bbolt
+	maxKeySize = bolt.MaxKeySize
+	// maxLeasedKeySize is the longest key a lease can be attached to, leased keys are
+	// indexed under the 8 bytes of the lease ID followed by the key
+	maxLeasedKeySize = maxKeySize - 8
+)
+
+var (
+	kvBucket        = []byte("kv")        // key -> mod index uvarint | lease varint | value
+	leaseBucket     = []byte("leases")    // lease ID -> TTL varint
+	leaseKeysBucket = []byte("leaseKeys") // lease ID | key -> empty
+	metaBucket      = []byte("meta")
+
+	appliedIndexKey = []byte("appliedIndex")
+)
+
+// dbSnapshotHeader describes the database file following it
+type dbSnapshotHeader struct {
+	Version      uint32
+	AppliedIndex uint64
+	Size         uint64
+}
+
+// boltBackend keeps the content in a bbolt database file, so it neither has to fit
+// into memory nor gets rebuilt on start. Each update is committed together with the
+// applied index, so the store knows which raft log entries it already holds.
+type boltBackend struct {
+	path string
+	mu   sync.RWMutex // guards db while a snapshot replaces the file
+	db   *bolt.DB
+}
+
+func openBoltBackend(path string) (*boltBackend, error) {
+	db, err := openBolt(path)
+	if err != nil {
+		return nil, err
+	}
+	return &boltBackend{path: path, db: db}, nil
+}
+
+func openBolt(path string) (*bolt.DB, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("cannot open store %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{kvBucket, leaseBucket, leaseKeysBucket, metaBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("cannot initialize store %s: %w", path, err)
+	}
+	return db, nil
+}
+
+func (b *boltBackend) view(fn func(tx backendTx) error) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.db.View(func(tx *bolt.Tx) error {
+		return fn(newBoltTx(tx))
+	})
+}
+
+func (b *boltBackend) update(index uint64, fn func(tx backendTx) error) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.db.Update(func(tx *bolt.Tx) error {
+		btx := newBoltTx(tx)
+		if err := fn(btx); err != nil {
+			return err
+		}
+		btx.setAppliedIndex(index)
+		return btx.err
+	})
+}
+
+func (b *boltBackend) reset(index uint64, fn func(tx backendTx) error) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{kvBucket, leaseBucket, leaseKeysBucket} {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+		btx := newBoltTx(tx)
+		if err := fn(btx); err != nil {
+			return err
+		}
+		btx.setAppliedIndex(index)
+		return btx.err
+	})
+}
+
+func (b *boltBackend) appliedIndex() uint64 {
+	var index uint64
+	b.view(func(tx backendTx) error {
+		index = tx.(*boltTx).appliedIndex()
+		return nil
+	})
+	return index
+}
+
+// snapshot streams the database file straight from disk, as of the read transaction
+// begun by the call. The file can't be replaced until it's been written.
+func (b *boltBackend) snapshot() (func(w io.Writer) error, error) {
+	b.mu.RLock()
+	tx, err := b.db.Begin(false)
+	if err != nil {
+		b.mu.RUnlock()
+		return nil, err
+	}
+	return func(w io.Writer) error {
+		defer b.mu.RUnlock()
+		defer tx.Rollback()
+		h := crc32.New(crcTable)
+		mw := io.MultiWriter(w, h)
+		header := dbSnapshotHeader{
+			Version:      dbSnapshotVersion,
+			AppliedIndex: newBoltTx(tx).appliedIndex(),
+			Size:         uint64(tx.Size()),
+		}
+		if _, err := io.WriteString(mw, dbSnapshotMagic); err != nil {
+			return err
+		}
+		if err := binary.Write(mw, binary.BigEndian, header); err != nil {
+			return err
+		}
+		if _, err := tx.WriteTo(mw); err != nil {
+			return err
+		}
+		return binary.Write(w, binary.BigEndian, h.Sum32())
+	}, nil
+}
+
+// restoreDB replaces the database file with the one of the snapshot, it's written
+// next to the current file and swapped in once the checksum has been verified.
+func (b *boltBackend) restoreDB(r io.Reader, index uint64) error {
+	tmp := b.path + ".restore"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = readDBSnapshot(r, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.db.Close(); err != nil {
+		return err
+	}
+	renameErr := os.Rename(tmp, b.path)
+	// reopen the previous file if it couldn't be replaced
+	db, err := openBolt(b.path)
+	if err != nil {
+		return err
+	}
+	b.db = db
+	if renameErr != nil {
+		os.Remove(tmp)
+		return renameErr
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		btx := newBoltTx(tx)
+		btx.setAppliedIndex(index)
+		return btx.err
+	})
+}
+
+func (b *boltBackend) close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.db.Close()
+}
+
+// readDBSnapshot writes the database file of the snapshot to f and verifies its checksum.
+func readDBSnapshot(r io.Reader, f *os.File) error {
+	sr := &snapshotReader{r: bufio.NewReader(r), h: crc32.New(crcTable)}
+
+	magic := make([]byte, len(dbSnapshotMagic))
+	if _, err := io.ReadFull(sr, magic); err != nil {
+		return err
+	}
+	if string(magic) != dbSnapshotMagic {
+		return fmt.Errorf("not a database snapshot, unexpected magic %q", magic)
+	}
+	var header dbSnapshotHeader
+	if err := binary.Read(sr, binary.BigEndian, &header); err != nil {
+		return err
+	}
+	if header.Version > dbSnapshotVersion {
+		return fmt.Errorf("unsupported database snapshot version %d", header.Version)
+	}
+	if _, err := io.CopyN(f, sr, int64(header.Size)); err != nil {
+		return err
+	}
+
+	sum := sr.h.Sum32()
+	var want uint32
+	if err := binary.Read(sr.r, binary.BigEndian, &want); err != nil {
+		return err
+	}
+	if sum != want {
+		return errSnapshotChecksum
+	}
+	return f.Sync()
+}
+
+// boltTx reads and writes the buckets of a bbolt transaction. The first failed
+// write is kept in err and fails the whole transaction.
+type boltTx struct {
+	kv, leases, attached, meta *bolt.Bucket
+	err                        error
+}
+
+func newBoltTx(tx *bolt.Tx) *boltTx {
+	return &boltTx{
+		kv:       tx.Bucket(kvBucket),
+		leases:   tx.Bucket(leaseBucket),
+		attached: tx.Bucket(leaseKeysBucket),
+		meta:     tx.Bucket(metaBucket),
+	}
+}
+
+func (t *boltTx) check(err error) {
+	if t.err == nil {
+		t.err = err
+	}
+}
+
+func (t *boltTx) get(key string) (string, keyMeta, bool) {
+	v := t.kv.Get([]byte(key))
+	if v == nil {
+		return "", keyMeta{}, false
+	}
+	val, meta := decodeBoltValue(v)
+	return val, meta, true
+}
+
+func (t *boltTx) ascend(start, end string, fn func(key, val string, meta keyMeta) bool) {
+	c := t.kv.Cursor()
+	for k, v := c.Seek([]byte(start)); k != nil; k, v = c.Next() {
+		if end != "" && string(k) >= end {
+			return
+		}
+		val, meta := decodeBoltValue(v)
+		if !fn(string(k), val, meta) {
+			return
+		}
+	}
+}
+
+func (t *boltTx) put(key, val string, meta keyMeta) {
+	t.detach(key)
+	if meta.Lease != 0 {
+		t.check(t.attached.Put(boltLeaseKey(meta.Lease, key), []byte{}))
+	}
+	t.check(t.kv.Put([]byte(key), encodeBoltValue(val, meta)))
+}
+
+func (t *boltTx) delete(key string) {
+	t.detach(key)
+	t.check(t.kv.Delete([]byte(key)))
+}
+
+// detach removes the key from the lease it's attached to
+func (t *boltTx) detach(key string) {
+	if _, meta, ok := t.get(key); ok && meta.Lease != 0 {
+		t.check(t.attached.Delete(boltLeaseKey(meta.Lease, key)))
+	}
+}
+
+func (t *boltTx) forEachLease(fn func(id, ttl int64)) {
+	t.leases.ForEach(func(k, v []byte) error {
+		ttl, _ := binary.Varint(v)
+		fn(int64(binary.BigEndian.Uint64(k)), ttl)
+		return nil
+	})
+}
+
+func (t *boltTx) putLease(id, ttl int64) {
+	t.check(t.leases.Put(boltLeaseKey(id, ""), binary.AppendVarint(nil, ttl)))
+}
+
+func (t *boltTx) deleteLease(id int64) {
+	// deleting while iterating with a cursor skips keys
+	for _, key := range t.leaseKeys(id) {
+		t.check(t.attached.Delete(boltLeaseKey(id, key)))
+	}
+	t.check(t.leases.Delete(boltLeaseKey(id, "")))
+}
+
+func (t *boltTx) leaseKeys(id int64) []string {
+	prefix := boltLeaseKey(id, "")
+	var keys []string
+	c := t.attached.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		keys = append(keys, string(k[len(prefix):]))
+	}
+	return keys
+}
+
+func (t *boltTx) appliedIndex() uint64 {
+	v := t.meta.Get(appliedIndexKey)
+	if len(v) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(v)
+}
+
+func (t *boltTx) setAppliedIndex(index uint64) {
+	t.check(t.meta.Put(appliedIndexKey, binary.BigEndian.AppendUint64(nil, index)))
+}
+
+func boltLeaseKey(id int64, key string) []byte {
+	return append(binary.BigEndian.AppendUint64(nil, uint64(id)), key...)
+}
+
+func encodeBoltValue(val string, meta keyMeta) []byte {
+	buf := binary.AppendUvarint(nil, meta.ModIndex)
+	buf = binary.AppendVarint(buf, meta.Lease)
+	return append(buf, val...)
+}
+
+func decodeBoltValue(v []byte) (string, keyMeta) {
+	modIndex, n := binary.Uvarint(v)
+	lease, m := binary.Varint(v[n:])
+	return string(v[n+m:]), keyMeta{ModIndex: modIndex, Lease: lease}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// proposal waits to be handed over to raft with the next batch
+type proposal struct {
+	ctx  context.Context // proposals whose context is done by then are left out
+	data []byte
+	errc chan error // gets raft's decision on the entry, nil if nobody waits for it
+	// cancel releases ctx once raft decided on the entry, set if nobody waits for it
+	cancel context.CancelFunc
+}
+
+// proposeBatches hands proposals received over propc over to raft in batches, until
+// the node stops. Proposals queued while the previous batch was handed over, or arriving
+// within BatchInterval after the first one, are proposed together, so raft appends,
+// persists and replicates them in the same Ready cycle. Collecting stops once the batch
+// reaches MaxBatchSize bytes. Every proposal keeps its own log entry.
+func (rc *raftNode) proposeBatches() {
+	for {
+		select {
+		case p := <-rc.propc:
+			rc.proposeBatch(rc.collectBatch(p))
+		case <-rc.donec:
+			return
+		}
+	}
+}
+
+// collectBatch returns the proposal together with the ones following it within the
+// batch interval and size
+func (rc *raftNode) collectBatch(first proposal) []proposal {
+	batch := []proposal{first}
+	size := uint64(len(first.data))
+
+	var deadline <-chan time.Time
+	if rc.cfg.BatchInterval > 0 {
+		timer := time.NewTimer(rc.cfg.BatchInterval)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	for size < rc.cfg.MaxBatchSize {
+		if deadline == nil {
+			select {
+			case p := <-rc.propc:
+				batch = append(batch, p)
+				size += uint64(len(p.data))
+				continue
+			default:
+				return batch
+			}
+		}
+		select {
+		case p := <-rc.propc:
+			batch = append(batch, p)
+			size += uint64(len(p.data))
+		case <-deadline:
+			return batch
+		case <-rc.donec:
+			return batch
+		}
+	}
+	return batch
+}
+
+// proposeBatch proposes the entries back to back while the raft loop holds back the
+// next Ready, so raft hands them over in a single one. Every proposer gets raft's
+// decision on its entry, like ErrProposalDropped.
+func (rc *raftNode) proposeBatch(batch []proposal) {
+	proposalBatchEntries.Observe(float64(len(batch)))
+	if !rc.holdReady() {
+		for _, p := range batch {
+			p.result(errStopped)
+		}
+		return
+	}
+	defer rc.releaseReady()
+
+	for _, p := range batch {
+		if err := p.ctx.Err(); err != nil {
+			// the proposer gave up already
+			p.result(err)
+			continue
+		}
+		// waits until raft appended or forwarded the entry, or dropped it
+		p.result(rc.node.Propose(p.ctx, p.data))
+	}
+}
+
+func (p proposal) result(err error) {
+	if p.cancel != nil {
+		p.cancel()
+	}
+	if p.errc != nil {
+		p.errc <- err
+	}
+}
+
+// holdReady has the raft loop hold back the next Ready, for a tick at most. It returns
+// false if the raft loop returned already.
+func (rc *raftNode) holdReady() bool {
+	select {
+	case rc.holdc <- struct{}{}:
+		return true
+	case <-rc.stoppedc:
+		return false
+	}
+}
+
+// releaseReady lets the raft loop take the Ready again
+func (rc *raftNode) releaseReady() {
+	select {
+	case rc.releasec <- struct{}{}:
+	case <-rc.stoppedc:
+	}
+}
+
+// propose queues the data for the next batch and waits for raft's decision on it
+func (rc *raftNode) propose(ctx context.Context, data []byte) error {
+	p := proposal{ctx: ctx, data: data, errc: make(chan error, 1)}
+	select {
+	case rc.propc <- p:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-rc.donec:
+		return errStopped
+	}
+
+	select {
+	case err := <-p.errc:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-rc.donec:
+		return errStopped
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"go.etcd.io/etcd/client/pkg/v3/transport"
+	"go.etcd.io/etcd/client/pkg/v3/types"
+	"go.etcd.io/etcd/server/v3/etcdserver/api/rafthttp"
+)
+
+const (
+	// clusterIDPath serves the cluster ID of a member to nodes joining the cluster
+	clusterIDPath = "/cluster/id"
+	// legacyClusterID is the ID of clusters whose WALs were created before cluster IDs
+	// got persisted, so they keep talking to each other
+	legacyClusterID types.ID = 0x1000
+
+	// clusterIDFetchTimeout bounds how long a joining node looks for a member to
+	// learn the cluster ID from
+	clusterIDFetchTimeout = 10 * time.Second
+	clusterIDRetryDelay   = 200 * time.Millisecond
+)
+
+// walMetadata is written once a WAL is created and read back on every start
+type walMetadata struct {
+	NodeID    uint64   `json:"nodeID"`
+	ClusterID types.ID `json:"clusterID"`
+}
+
+// clusterIDOf derives the cluster ID from the cluster token and the peer URLs of the
+// initial members, in any order. Clusters bootstrapped with a different token or
+// different members get a different ID, so their members reject each other's messages.
+// Parts are separated by NUL, which URLs can't contain, so no two splits of the same
+// bytes into URLs and token hash alike.
+func clusterIDOf(token string, peers []string) types.ID {
+	urls := append([]string(nil), peers...)
+	sort.Strings(urls)
+	sum := sha1.Sum([]byte(strings.Join(append(urls, token), "\x00")))
+	return types.ID(binary.BigEndian.Uint64(sum[:8]))
+}
+
+// initialClusterID returns the ID of the cluster a node without WAL starts in. Nodes
+// bootstrapping the cluster derive it, joining nodes ask the other members.
+func (rc *raftNode) initialClusterID() (types.ID, error) {
+	if !rc.join {
+		return clusterIDOf(rc.cfg.ClusterToken, rc.peers), nil
+	}
+	var tlsInfo transport.TLSInfo
+	if info := rc.cfg.tlsInfo(); info != nil {
+		tlsInfo = *info
+	}
+	rt, err := rafthttp.NewRoundTripper(tlsInfo, time.Second)
+	if err != nil {
+		return 0, &raftError{op: "create client to fetch cluster ID", err: err}
+	}
+	client := &http.Client{Transport: rt, Timeout: time.Second}
+
+	deadline := time.Now().Add(clusterIDFetchTimeout)
+	for {
+		for i, peer := range rc.peers {
+			if i+1 == rc.id {
+				continue
+			}
+			id, err := fetchClusterID(client, peer)
+			if err == nil {
+				log.Printf("joining cluster %s as reported by %s", id, peer)
+				return id, nil
+			}
+			log.Printf("cannot fetch cluster ID from %s (%v)", peer, err)
+		}
+		if time.Now().After(deadline) {
+			return 0, &raftError{op: "fetch cluster ID", err: errors.New("no member of the cluster reachable")}
+		}
+		time.Sleep(clusterIDRetryDelay)
+	}
+}
+
+func fetchClusterID(client *http.Client, peer string) (types.ID, error) {
+	resp, err := client.Get(peer + clusterIDPath)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return types.IDFromString(strings.TrimSpace(string(body)))
+}
+
+// checkClusterID sets the cluster ID kept in the WAL metadata. It's derived from the
+// token and peers only when the cluster is bootstrapped, restarted members keep it
+// when their peers changed with the membership. Members of another cluster are
+// rejected by the transport, which checks the ID of every message.
+func (rc *raftNode) checkClusterID(metadata []byte) error {
+	if len(metadata) == 0 {
+		log.Printf("WAL of member %d has no cluster ID, using legacy cluster ID %s", rc.id, legacyClusterID)
+		rc.clusterID = legacyClusterID
+		return nil
+	}
+	var md walMetadata
+	if err := json.Unmarshal(metadata, &md); err != nil {
+		return &raftError{op: "decode WAL metadata", err: err}
+	}
+	if md.NodeID != uint64(rc.id) {
+		return &raftError{op: "check WAL metadata", err: fmt.Errorf("WAL belongs to member %d", md.NodeID)}
+	}
+	rc.clusterID = md.ClusterID
+	return nil
+}
+
+// serveClusterID writes the cluster ID for nodes joining the cluster
+func (rc *raftNode) serveClusterID(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	fmt.Fprintln(w, rc.clusterID)
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+
+	storeV1 "github/m-wrona/raft-go/model/store/v1"
+)
+
+const (
+	// commandVersion is the newest command format this replica understands
+	commandVersion = 1
+	// commandMagic starts every protobuf encoded command. Gob streams never start with
+	// a zero byte, so it tells commands apart from gob entries written by older replicas.
+	commandMagic = 0x00
+)
+
+// errUnsupportedCommand is returned for commands of newer versions or unknown ops,
+// replicas stop applying the log at them until they're upgraded.
+var errUnsupportedCommand = errors.New("unsupported command")
+
+var (
+	opToProto = map[op]storeV1.Command_Op{
+		opPut:            storeV1.Command_PUT,
+		opDelete:         storeV1.Command_DELETE,
+		opTxn:            storeV1.Command_TXN,
+		opLeaseGrant:     storeV1.Command_LEASE_GRANT,
+		opLeaseKeepAlive: storeV1.Command_LEASE_KEEP_ALIVE,
+		opLeaseRevoke:    storeV1.Command_LEASE_REVOKE,
+		opAuth:           storeV1.Command_AUTH,
+	}
+	opFromProto = map[storeV1.Command_Op]op{}
+)
+
+func init() {
+	for o, p := range opToProto {
+		opFromProto[p] = o
+	}
+}
+
+// encodeCommand encodes the command into the versioned protobuf envelope
+func encodeCommand(cmd command) ([]byte, error) {
+	pbOp, ok := opToProto[cmd.Op]
+	if !ok {
+		return nil, fmt.Errorf("%w: op %d", errUnsupportedCommand, cmd.Op)
+	}
+
+	var payload proto.Message
+	switch cmd.Op {
+	case opPut:
+		payload = &storeV1.Put{Key: cmd.Key, Value: []byte(cmd.Val), Lease: cmd.Lease}
+	case opDelete:
+		payload = &storeV1.Delete{Key: cmd.Key}
+	case opTxn:
+		payload = txnToProto(cmd.Txn)
+	case opLeaseGrant, opLeaseKeepAlive, opLeaseRevoke:
+		payload = &storeV1.Lease{Id: cmd.Lease, Ttl: cmd.TTL}
+	case opAuth:
+		payload = authChangeToProto(cmd.Auth)
+	}
+	data, err := proto.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	envelope, err := proto.Marshal(&storeV1.Command{
+		Version: commandVersion,
+		Op:      pbOp,
+		Id:      cmd.ID,
+		Payload: data,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{commandMagic}, envelope...), nil
+}
+
+// decodeCommand decodes a command committed to the log. Entries without the magic
+// byte are decoded as gob commands written before the protobuf envelope. Commands
+// the replica doesn't support are returned with their ID and errUnsupportedCommand.
+func decodeCommand(data []byte) (command, error) {
+	if len(data) == 0 || data[0] != commandMagic {
+		var cmd command
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&cmd); err != nil {
+			return cmd, err
+		}
+		if _, ok := opToProto[cmd.Op]; !ok {
+			return cmd, fmt.Errorf("%w: op %d", errUnsupportedCommand, cmd.Op)
+		}
+		return cmd, nil
+	}
+
+	var envelope storeV1.Command
+	if err := proto.Unmarshal(data[1:], &envelope); err != nil {
+		return command{}, err
+	}
+	cmd := command{ID: envelope.Id}
+	if envelope.Version > commandVersion {
+		return cmd, fmt.Errorf("%w: version %d", errUnsupportedCommand, envelope.Version)
+	}
+	o, ok := opFromProto[envelope.Op]
+	if !ok {
+		return cmd, fmt.Errorf("%w: op %s", errUnsupportedCommand, envelope.Op)
+	}
+	cmd.Op = o
+
+	switch o {
+	case opPut:
+		var put storeV1.Put
+		if err := proto.Unmarshal(envelope.Payload, &put); err != nil {
+			return cmd, err
+		}
+		cmd.Key, cmd.Val, cmd.Lease = put.Key, string(put.Value), put.Lease
+	case opDelete:
+		var del storeV1.Delete
+		if err := proto.Unmarshal(envelope.Payload, &del); err != nil {
+			return cmd, err
+		}
+		cmd.Key = del.Key
+	case opTxn:
+		var t storeV1.Txn
+		if err := proto.Unmarshal(envelope.Payload, &t); err != nil {
+			return cmd, err
+		}
+		txn, err := txnFromProto(&t)
+		if err != nil {
+			return cmd, err
+		}
+		cmd.Txn = txn
+	case opLeaseGrant, opLeaseKeepAlive, opLeaseRevoke:
+		var l storeV1.Lease
+		if err := proto.Unmarshal(envelope.Payload, &l); err != nil {
+			return cmd, err
+		}
+		cmd.Lease, cmd.TTL = l.Id, l.Ttl
+	case opAuth:
+		var c storeV1.AuthChange
+		if err := proto.Unmarshal(envelope.Payload, &c); err != nil {
+			return cmd, err
+		}
+		cmd.Auth = authChangeFromProto(&c)
+	}
+	return cmd, nil
+}
+
+func txnToProto(t *txn) *storeV1.Txn {
+	if t == nil {
+		return &storeV1.Txn{}
+	}
+	pb := &storeV1.Txn{
+		Compares: make([]*storeV1.Compare, 0, len(t.Compares)),
+		Success:  txnOpsToProto(t.Success),
+		Failure:  txnOpsToProto(t.Failure),
+	}
+	for _, c := range t.Compares {
+		pb.Compares = append(pb.Compares, &storeV1.Compare{
+			Result:   storeV1.Compare_CompareResult(c.Result),
+			Target:   storeV1.Compare_CompareTarget(c.Target),
+			Key:      c.Key,
+			Value:    []byte(c.Val),
+			ModIndex: c.ModIndex,
+		})
+	}
+	return pb
+}
+
+func txnOpsToProto(ops []txnOp) []*storeV1.TxnOp {
+	pb := make([]*storeV1.TxnOp, 0, len(ops))
+	for _, o := range ops {
+		switch o.Op {
+		case opPut:
+			pb = append(pb, &storeV1.TxnOp{Op: &storeV1.TxnOp_Put{Put: &storeV1.Put{Key: o.Key, Value: []byte(o.Val)}}})
+		case opDelete:
+			pb = append(pb, &storeV1.TxnOp{Op: &storeV1.TxnOp_Delete{Delete: &storeV1.Delete{Key: o.Key}}})
+		}
+	}
+	return pb
+}
+
+func txnFromProto(pb *storeV1.Txn) (*txn, error) {
+	t := &txn{Compares: make([]compare, 0, len(pb.Compares))}
+	for _, c := range pb.Compares {
+		t.Compares = append(t.Compares, compare{
+			Key:      c.Key,
+			Target:   compareTarget(c.Target),
+			Result:   compareResult(c.Result),
+			Val:      string(c.Value),
+			ModIndex: c.ModIndex,
+		})
+	}
+	var err error
+	if t.Success, err = txnOpsFromProto(pb.Success); err != nil {
+		return nil, err
+	}
+	if t.Failure, err = txnOpsFromProto(pb.Failure); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func txnOpsFromProto(pb []*storeV1.TxnOp) ([]txnOp, error) {
+	ops := make([]txnOp, 0, len(pb))
+	for _, o := range pb {
+		switch {
+		case o.GetPut() != nil:
+			ops = append(ops, txnOp{Op: opPut, Key: o.GetPut().Key, Val: string(o.GetPut().Value)})
+		case o.GetDelete() != nil:
+			ops = append(ops, txnOp{Op: opDelete, Key: o.GetDelete().Key})
+		default:
+			// skipping the op would apply a different transaction than proposed
+			return nil, fmt.Errorf("%w: transaction op", errUnsupportedCommand)
+		}
+	}
+	return ops, nil
+}
+
+func authChangeToProto(c *authChange) *storeV1.AuthChange {
+	if c == nil {
+		return &storeV1.AuthChange{}
+	}
+	return &storeV1.AuthChange{
+		Action:       storeV1.AuthChange_Action(c.Action),
+		User:         c.User,
+		Role:         c.Role,
+		PasswordHash: c.PasswordHash,
+		Permission: &storeV1.Permission{
+			Method:    c.Perm.Method,
+			KeyPrefix: c.Perm.KeyPrefix,
+			Type:      uint32(c.Perm.Type),
+		},
+	}
+}
+
+func authChangeFromProto(pb *storeV1.AuthChange) *authChange {
+	return &authChange{
+		Action:       authAction(pb.Action),
+		User:         pb.User,
+		Role:         pb.Role,
+		PasswordHash: pb.PasswordHash,
+		Perm: permission{
+			Method:    pb.GetPermission().GetMethod(),
+			KeyPrefix: pb.GetPermission().GetKeyPrefix(),
+			Type:      permType(pb.GetPermission().GetType()),
+		},
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+// Config of a node. It's loaded from environment variables, named in the envconfig
+// tags, and command line flags, flags take precedence.
+type Config struct {
+	ID          int    `envconfig:"NODE_ID"`
+	Cluster     string `envconfig:"NODE_CLUSTER"` // comma separated raft peer URLs
+	Join        bool   `envconfig:"NODE_JOIN"`
+	StorePath   string `envconfig:"NODE_STORE_PATH"`
+	Backend     string `envconfig:"NODE_BACKEND"`
+	GRPCCluster string `envconfig:"GRPC_CLUSTER"` // comma separated gRPC addresses of peers
+	Network     string `envconfig:"GRPC_NETWORK"`
+	Address     string `envconfig:"GRPC_ADDR"`
+
+	// GRPCCertFile and GRPCKeyFile serve the gRPC API over TLS, clients have to
+	// present a certificate signed by GRPCTrustedCAFile if it's set
+	GRPCCertFile      string `envconfig:"GRPC_CERT_FILE"`
+	GRPCKeyFile       string `envconfig:"GRPC_KEY_FILE"`
+	GRPCTrustedCAFile string `envconfig:"GRPC_TRUSTED_CA_FILE"`
+
+	// WALSegmentSize is the size WAL files are preallocated to and cut at
+	WALSegmentSize int64 `envconfig:"WAL_SEGMENT_SIZE"`
+
+	// MetricsAddr serves metrics over plain HTTP besides the peer URL, which requires
+	// a peer certificate once peer traffic uses TLS
+	MetricsAddr string `envconfig:"NODE_METRICS_ADDR"`
+
+	// ShutdownTimeout bounds draining proposals, handing leadership over and serving
+	// requests in flight once the node got interrupted
+	ShutdownTimeout time.Duration `envconfig:"NODE_SHUTDOWN_TIMEOUT"`
+
+	Raft RaftConfig `envconfig:"RAFT"`
+}
+
+// RaftConfig tunes the raft node. Slow links call for longer ticks or elections and
+// smaller messages.
+type RaftConfig struct {
+	// TickInterval is the duration of a logical raft clock tick
+	TickInterval time.Duration `envconfig:"TICK_INTERVAL"`
+	// ElectionTick is the number of ticks a follower waits for the leader before it
+	// starts an election
+	ElectionTick int `envconfig:"ELECTION_TICK"`
+	// HeartbeatTick is the number of ticks between heartbeats of the leader
+	HeartbeatTick int `envconfig:"HEARTBEAT_TICK"`
+
+	MaxSizePerMsg             uint64 `envconfig:"MAX_SIZE_PER_MSG"`
+	MaxInflightMsgs           int    `envconfig:"MAX_INFLIGHT_MSGS"`
+	MaxUncommittedEntriesSize uint64 `envconfig:"MAX_UNCOMMITTED_ENTRIES_SIZE"` // 0 means no limit
+
+	// MaxBatchSize bounds the bytes of concurrent proposals handed over to raft at once,
+	// a batch is persisted and replicated together
+	MaxBatchSize uint64 `envconfig:"MAX_BATCH_SIZE"`
+	// BatchInterval is how long a batch waits for more proposals, with 0 it only takes
+	// the proposals queued meanwhile
+	BatchInterval time.Duration `envconfig:"BATCH_INTERVAL"`
+
+	// SnapshotCount is the number of applied entries a snapshot is taken after
+	SnapshotCount uint64 `envconfig:"SNAPSHOT_COUNT"`
+	// SnapshotCatchUpEntries is the number of entries kept in the log after a snapshot,
+	// so slow followers catch up without a snapshot transfer
+	SnapshotCatchUpEntries uint64 `envconfig:"SNAPSHOT_CATCHUP_ENTRIES"`
+
+	// ClusterToken is mixed into the ID of the cluster bootstrapped by the initial
+	// peers, clusters with the same peers but different tokens reject each other
+	ClusterToken string `envconfig:"CLUSTER_TOKEN"`
+
+	// CertFile and KeyFile enable TLS between peers, which then have to present
+	// certificates signed by TrustedCAFile. Peer URLs have to use https then.
+	CertFile      string `envconfig:"CERT_FILE"`
+	KeyFile       string `envconfig:"KEY_FILE"`
+	TrustedCAFile string `envconfig:"TRUSTED_CA_FILE"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		ID:              1,
+		Cluster:         "http://127.0.0.1:9021",
+		StorePath:       "./",
+		Backend:         "memory",
+		Network:         "tcp",
+		Address:         "0.0.0.0:9121",
+		WALSegmentSize:  64 * 1024 * 1024,
+		ShutdownTimeout: 10 * time.Second,
+		Raft:            defaultRaftConfig(),
+	}
+}
+
+func defaultRaftConfig() RaftConfig {
+	return RaftConfig{
+		TickInterval:              100 * time.Millisecond,
+		ElectionTick:              10,
+		HeartbeatTick:             1,
+		MaxSizePerMsg:             1024 * 1024,
+		MaxInflightMsgs:           256,
+		MaxUncommittedEntriesSize: 1 << 30,
+		MaxBatchSize:              1024 * 1024,
+		SnapshotCount:             10000,
+		SnapshotCatchUpEntries:    10000,
+		ClusterToken:              "raft-go-cluster",
+	}
+}
+
+// loadConfig reads the configuration from the environment and then from the flags
+// parsed from args, and validates it.
+func loadConfig(fs *flag.FlagSet, args []string) (Config, error) {
+	cfg := defaultConfig()
+	if err := envconfig.Process("", &cfg); err != nil {
+		return Config{}, err
+	}
+
+	fs.IntVar(&cfg.ID, "id", cfg.ID, "node ID")
+	fs.StringVar(&cfg.Cluster, "cluster", cfg.Cluster, "comma separated cluster peers")
+	fs.BoolVar(&cfg.Join, "join", cfg.Join, "join an existing cluster")
+	fs.StringVar(&cfg.StorePath, "storePath", cfg.StorePath, "path where raft state will be kept")
+	fs.StringVar(&cfg.Backend, "backend", cfg.Backend, "key-value store backend: memory, or bolt to keep the store on disk")
+	fs.StringVar(&cfg.GRPCCluster, "grpcCluster", cfg.GRPCCluster, "comma separated gRPC addresses of cluster peers, in the same order as cluster")
+	fs.StringVar(&cfg.Network, "grpcNetwork", cfg.Network, "network the gRPC server listens on")
+	fs.StringVar(&cfg.Address, "grpcAddr", cfg.Address, "address the gRPC server listens on")
+	fs.StringVar(&cfg.GRPCCertFile, "grpcCertFile", cfg.GRPCCertFile, "certificate of the gRPC server, enables TLS")
+	fs.StringVar(&cfg.GRPCKeyFile, "grpcKeyFile", cfg.GRPCKeyFile, "key of the gRPC server certificate")
+	fs.StringVar(&cfg.GRPCTrustedCAFile, "grpcTrustedCAFile", cfg.GRPCTrustedCAFile, "CA gRPC client certificates have to be signed by, enables client certificate verification")
+	fs.Int64Var(&cfg.WALSegmentSize, "walSegmentSize", cfg.WALSegmentSize, "size of WAL segment files in bytes")
+	fs.StringVar(&cfg.MetricsAddr, "metricsAddr", cfg.MetricsAddr, "address metrics are additionally served on over plain HTTP")
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdownTimeout", cfg.ShutdownTimeout, "time to drain proposals, hand leadership over and serve requests in flight on shutdown")
+	fs.DurationVar(&cfg.Raft.TickInterval, "tickInterval", cfg.Raft.TickInterval, "duration of a raft tick")
+	fs.IntVar(&cfg.Raft.ElectionTick, "electionTick", cfg.Raft.ElectionTick, "ticks without a leader before an election starts")
+	fs.IntVar(&cfg.Raft.HeartbeatTick, "heartbeatTick", cfg.Raft.HeartbeatTick, "ticks between leader heartbeats")
+	fs.Uint64Var(&cfg.Raft.MaxSizePerMsg, "maxSizePerMsg", cfg.Raft.MaxSizePerMsg, "max size of append messages in bytes")
+	fs.IntVar(&cfg.Raft.MaxInflightMsgs, "maxInflightMsgs", cfg.Raft.MaxInflightMsgs, "max number of in-flight append messages")
+	fs.Uint64Var(&cfg.Raft.MaxUncommittedEntriesSize, "maxUncommittedEntriesSize", cfg.Raft.MaxUncommittedEntriesSize, "max size of uncommitted entries in bytes, 0 for no limit")
+	fs.Uint64Var(&cfg.Raft.MaxBatchSize, "maxBatchSize", cfg.Raft.MaxBatchSize, "max size of proposals handed over to raft at once in bytes")
+	fs.DurationVar(&cfg.Raft.BatchInterval, "batchInterval", cfg.Raft.BatchInterval, "time a batch of proposals waits for more, 0 to take only the queued ones")
+	fs.Uint64Var(&cfg.Raft.SnapshotCount, "snapshotCount", cfg.Raft.SnapshotCount, "applied entries between snapshots")
+	fs.Uint64Var(&cfg.Raft.SnapshotCatchUpEntries, "snapshotCatchUpEntries", cfg.Raft.SnapshotCatchUpEntries, "entries kept in the log after a snapshot for slow followers")
+	fs.StringVar(&cfg.Raft.ClusterToken, "clusterToken", cfg.Raft.ClusterToken, "token of the initial cluster, the cluster ID is derived from it and the peers")
+	fs.StringVar(&cfg.Raft.CertFile, "peerCertFile", cfg.Raft.CertFile, "certificate of the node for peer traffic, enables TLS")
+	fs.StringVar(&cfg.Raft.KeyFile, "peerKeyFile", cfg.Raft.KeyFile, "key of the peer certificate")
+	fs.StringVar(&cfg.Raft.TrustedCAFile, "peerTrustedCAFile", cfg.Raft.TrustedCAFile, "CA peer certificates have to be signed by")
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	if err := cfg.validate(); err != nil {
+		return Config{}, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return cfg, nil
+}
+
+func (c Config) validate() error {
+	if err := c.Raft.validate(); err != nil {
+		return err
+	}
+	scheme := "http"
+	if c.Raft.CertFile != "" {
+		scheme = "https"
+	}
+	peers := strings.Split(c.Cluster, ",")
+	for _, peer := range peers {
+		u, err := url.Parse(peer)
+		if err != nil {
+			return fmt.Errorf("cluster has an invalid peer URL: %w", err)
+		}
+		if u.Scheme != scheme {
+			return fmt.Errorf("peer URL %q has to use %s", peer, scheme)
+		}
+	}
+	switch {
+	case c.ID < 1 || c.ID > len(peers):
+		return fmt.Errorf("id %d is not in the cluster of %d peers", c.ID, len(peers))
+	case c.GRPCCluster != "" && len(strings.Split(c.GRPCCluster, ",")) != len(peers):
+		return fmt.Errorf("grpcCluster has to list %d addresses like cluster", len(peers))
+	case c.Backend != "memory" && c.Backend != "bolt":
+		return fmt.Errorf("unknown backend %q", c.Backend)
+	case c.Address == "":
+		return errors.New("grpcAddr is empty")
+	case c.WALSegmentSize <= 0:
+		return errors.New("walSegmentSize has to be positive")
+	case c.ShutdownTimeout <= 0:
+		return errors.New("shutdownTimeout has to be positive")
+	case (c.GRPCCertFile == "") != (c.GRPCKeyFile == ""):
+		return errors.New("grpcCertFile and grpcKeyFile have to be set together")
+	case c.GRPCTrustedCAFile != "" && c.GRPCCertFile == "":
+		return errors.New("grpcTrustedCAFile requires grpcCertFile")
+	}
+	return nil
+}
+
+func (c RaftConfig) validate() error {
+	switch {
+	case c.TickInterval <= 0:
+		return errors.New("tickInterval has to be positive")
+	case c.HeartbeatTick <= 0:
+		return errors.New("heartbeatTick has to be positive")
+	case c.ElectionTick <= c.HeartbeatTick:
+		return fmt.Errorf("electionTick (%d) has to be greater than heartbeatTick (%d)", c.ElectionTick, c.HeartbeatTick)
+	case c.MaxSizePerMsg == 0:
+		return errors.New("maxSizePerMsg has to be positive")
+	case c.MaxInflightMsgs <= 0:
+		return errors.New("maxInflightMsgs has to be positive")
+	case c.MaxBatchSize == 0:
+		return errors.New("maxBatchSize has to be positive")
+	case c.BatchInterval < 0:
+		return errors.New("batchInterval must not be negative")
+	case c.SnapshotCount == 0:
+		return errors.New("snapshotCount has to be positive")
+	case c.ClusterToken == "":
+		return errors.New("clusterToken is empty")
+	case (c.CertFile == "") != (c.KeyFile == ""):
+		return errors.New("peerCertFile and peerKeyFile have to be set together")
+	case (c.CertFile == "") != (c.TrustedCAFile == ""):
+		return errors.New("peerTrustedCAFile has to be set together with peerCertFile to verify peers")
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testLoadConfig(args ...string) (Config, error) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return loadConfig(fs, args)
+}
+
+func Test_Config_Defaults(t *testing.T) {
+	cfg, err := testLoadConfig()
+	require.NoError(t, err)
+	require.Equal(t, defaultConfig(), cfg)
+}
+
+func Test_Config_FlagsOverrideEnvironment(t *testing.T) {
+	t.Setenv("RAFT_TICK_INTERVAL", "500ms")
+	t.Setenv("RAFT_ELECTION_TICK", "30")
+	t.Setenv("RAFT_MAX_SIZE_PER_MSG", "65536")
+	t.Setenv("GRPC_ADDR", "127.0.0.1:8080")
+	t.Setenv("NODE_ID", "2")
+
+	cfg, err := testLoadConfig(
+		"-cluster", "http://127.0.0.1:12379,http://127.0.0.1:22379",
+		"-electionTick", "50",
+		"-snapshotCount", "100",
+	)
+	require.NoError(t, err)
+
+	require.Equal(t, 2, cfg.ID)
+	require.Equal(t, "127.0.0.1:8080", cfg.Address)
+	require.Equal(t, 500*time.Millisecond, cfg.Raft.TickInterval)
+	require.Equal(t, 50, cfg.Raft.ElectionTick, "flag expected to take precedence")
+	require.Equal(t, uint64(65536), cfg.Raft.MaxSizePerMsg)
+	require.Equal(t, uint64(100), cfg.Raft.SnapshotCount)
+	require.Equal(t, defaultRaftConfig().HeartbeatTick, cfg.Raft.HeartbeatTick)
+}
+
+func Test_Config_Validation(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{name: "id out of cluster", args: []string{"-id", "2"}, want: "id 2"},
+		{name: "grpc cluster size", args: []string{"-grpcCluster", "a:1,b:2"}, want: "grpcCluster"},
+		{name: "unknown backend", args: []string{"-backend", "sqlite"}, want: "backend"},
+		{name: "election tick", args: []string{"-electionTick", "1"}, want: "electionTick"},
+		{name: "tick interval", args: []string{"-tickInterval", "0s"}, want: "tickInterval"},
+		{name: "inflight msgs", args: []string{"-maxInflightMsgs", "0"}, want: "maxInflightMsgs"},
+		{name: "batch size", args: []string{"-maxBatchSize", "0"}, want: "maxBatchSize"},
+		{name: "batch interval", args: []string{"-batchInterval", "-1ms"}, want: "batchInterval"},
+		{name: "snapshot count", args: []string{"-snapshotCount", "0"}, want: "snapshotCount"},
+		{name: "wal segment size", args: []string{"-walSegmentSize", "-1"}, want: "walSegmentSize"},
+		{name: "shutdown timeout", args: []string{"-shutdownTimeout", "0s"}, want: "shutdownTimeout"},
+		{name: "cluster token", args: []string{"-clusterToken", ""}, want: "clusterToken"},
+		{name: "peer key missing", args: []string{"-peerCertFile", "node.pem", "-peerTrustedCAFile", "ca.pem"}, want: "peerKeyFile"},
+		{name: "peer CA missing", args: []string{"-peerCertFile", "node.pem", "-peerKeyFile", "node-key.pem"}, want: "peerTrustedCAFile"},
+		{name: "peer TLS over http", args: []string{"-peerCertFile", "node.pem", "-peerKeyFile", "node-key.pem", "-peerTrustedCAFile", "ca.pem"}, want: "https"},
+		{name: "grpc key missing", args: []string{"-grpcCertFile", "server.pem"}, want: "grpcKeyFile"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := testLoadConfig(tt.args...)
+			require.Error(t, err)
+			require.True(t, strings.Contains(err.Error(), tt.want), "unexpected error: %s", err)
+		})
+	}
+
+	t.Setenv("RAFT_HEARTBEAT_TICK", "fast")
+	_, err := testLoadConfig()
+	require.Error(t, err, "malformed environment variable accepted")
+}
@@ -4,88 +4,246 @@ import (
 	"context"
 	"errors"
 	"strconv"
+	"time"
 
+	"go.etcd.io/etcd/raft/v3"
 	"go.etcd.io/etcd/raft/v3/raftpb"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
 
 	apiV1 "github/m-wrona/raft-go/model/api/v1"
+	apiV2 "github/m-wrona/raft-go/model/api/v2"
 	raftV1 "github/m-wrona/raft-go/model/raft/v1"
 )
 
 const (
 	valueKey = "sample-value"
+
+	// defaultRequestTimeout bounds requests whose context has no deadline
+	defaultRequestTimeout = 5 * time.Second
 )
 
 type controller struct {
-	log         *zap.Logger
-	store       *kvstore
-	confChangeC chan<- raftpb.ConfChange
+	log  *zap.Logger
+	kv   *kvController
+	node *raftNode
 }
 
 func newController(
 	server *grpc.Server,
 	log *zap.Logger,
 	store *kvstore,
-	confChangeC chan<- raftpb.ConfChange,
+	node *raftNode,
 ) *controller {
 	c := &controller{
-		log:         log.With(zap.String("component", "grpcController")),
-		store:       store,
-		confChangeC: confChangeC,
+		log:  log.With(zap.String("component", "grpcController")),
+		kv:   newKVController(log, store, node),
+		node: node,
 	}
 	grpc_health_v1.RegisterHealthServer(server, c)
 	apiV1.RegisterKeyValueServiceServer(server, c)
+	apiV2.RegisterKeyValueServiceServer(server, c.kv)
+	apiV2.RegisterLeaseServiceServer(server, newLeaseController(log, store))
+	apiV2.RegisterAuthServiceServer(server, newAuthController(log, store))
 	raftV1.RegisterRaftServiceServer(server, c)
 	return c
 }
 
 func (c *controller) Check(ctx context.Context, request *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
 	c.log.Debug("Healthcheck request received", zap.Any("request", request))
-	return &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING}, nil
+	return &grpc_health_v1.HealthCheckResponse{Status: c.servingStatus()}, nil
 }
 
 func (c *controller) Watch(request *grpc_health_v1.HealthCheckRequest, server grpc_health_v1.Health_WatchServer) error {
-	return server.Send(&grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING})
+	return server.Send(&grpc_health_v1.HealthCheckResponse{Status: c.servingStatus()})
+}
+
+// servingStatus reports nodes which stopped applying committed entries as not serving,
+// they keep replicating entries until they're restarted
+func (c *controller) servingStatus() grpc_health_v1.HealthCheckResponse_ServingStatus {
+	if c.node.applyError() != nil {
+		return grpc_health_v1.HealthCheckResponse_NOT_SERVING
+	}
+	return grpc_health_v1.HealthCheckResponse_SERVING
 }
 
 func (c *controller) Set(ctx context.Context, request *apiV1.SetValueRequest) (*apiV1.SetValueResponse, error) {
 	c.log.Debug("Set value request received", zap.Any("request", request))
 
-	c.store.Propose(valueKey, strconv.Itoa(int(request.Value)))
-	// Optimistic-- no waiting for ack from raft. Value is not yet
-	// committed so a subsequent GET on the key may return old value
-	return &apiV1.SetValueResponse{Ok: true}, nil
+	resp, err := c.kv.Put(ctx, &apiV2.PutRequest{
+		Key:   valueKey,
+		Value: []byte(strconv.Itoa(int(request.Value))),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &apiV1.SetValueResponse{Ok: true, Index: resp.Index}, nil
 }
 
 func (c *controller) Get(ctx context.Context, request *apiV1.GetValueRequest) (*apiV1.GetValueResponse, error) {
-	if v, ok := c.store.Lookup(valueKey); ok {
-		if i, err := strconv.Atoi(v); err != nil {
-			return nil, err
-		} else {
-			return &apiV1.GetValueResponse{Value: uint32(i)}, nil
-		}
+	resp, err := c.kv.Get(ctx, &apiV2.GetRequest{Key: valueKey})
+	if status.Code(err) == codes.NotFound {
+		return nil, status.Error(codes.NotFound, "value not found")
+	} else if err != nil {
+		return nil, err
+	}
+
+	if i, err := strconv.Atoi(string(resp.Value)); err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "value is not a number: %s", err)
+	} else {
+		return &apiV1.GetValueResponse{Value: uint32(i)}, nil
 	}
-	return nil, errors.New("value not found")
 }
 
 func (c *controller) Add(ctx context.Context, request *raftV1.NodeRequest) (*raftV1.NodeResponse, error) {
 	c.log.Debug("Add node request received", zap.Any("request", request))
+	data, err := memberContextOf(request)
+	if err != nil {
+		return nil, err
+	}
+	cc := raftpb.ConfChange{
+		Type:    raftpb.ConfChangeAddNode,
+		NodeID:  request.Id,
+		Context: data,
+	}
+	return c.proposeConfChange(ctx, cc)
+}
+
+func (c *controller) AddLearner(ctx context.Context, request *raftV1.NodeRequest) (*raftV1.NodeResponse, error) {
+	c.log.Debug("Add learner request received", zap.Any("request", request))
+	data, err := memberContextOf(request)
+	if err != nil {
+		return nil, err
+	}
+	cc := raftpb.ConfChange{
+		Type:    raftpb.ConfChangeAddLearnerNode,
+		NodeID:  request.Id,
+		Context: data,
+	}
+	return c.proposeConfChange(ctx, cc)
+}
+
+func (c *controller) Promote(ctx context.Context, request *raftV1.NodeRequest) (*raftV1.NodeResponse, error) {
+	c.log.Debug("Promote learner request received", zap.Any("request", request))
+	switch err := c.node.checkPromotion(request.Id); {
+	case errors.Is(err, errNoLeader):
+		return nil, status.Error(codes.Unavailable, "no leader available")
+	case errors.Is(err, errNotLeader):
+		return nil, notLeaderError(c.node.leader())
+	case errors.Is(err, errNotLearner):
+		return nil, status.Errorf(codes.InvalidArgument, "node %d is not a learner", request.Id)
+	case errors.Is(err, errLearnerNotReady):
+		return nil, status.Errorf(codes.FailedPrecondition, "learner %d hasn't caught up with the leader yet", request.Id)
+	}
+	// adding a learner as a node promotes it
 	cc := raftpb.ConfChange{
 		Type:   raftpb.ConfChangeAddNode,
 		NodeID: request.Id,
 	}
-	c.confChangeC <- cc
-	return &raftV1.NodeResponse{Ok: true}, nil
+	return c.proposeConfChange(ctx, cc)
 }
 
 func (c *controller) Remove(ctx context.Context, request *raftV1.NodeRequest) (*raftV1.NodeResponse, error) {
 	c.log.Debug("Remove node request received", zap.Any("request", request))
+	if request.Id == 0 {
+		return nil, status.Error(codes.InvalidArgument, "node ID must not be zero")
+	}
 	cc := raftpb.ConfChange{
 		Type:   raftpb.ConfChangeRemoveNode,
 		NodeID: request.Id,
 	}
-	c.confChangeC <- cc
+	return c.proposeConfChange(ctx, cc)
+}
+
+func (c *controller) MemberList(ctx context.Context, request *raftV1.MemberListRequest) (*raftV1.MemberListResponse, error) {
+	members := c.node.memberList()
+	resp := &raftV1.MemberListResponse{Members: make([]*raftV1.Member, 0, len(members))}
+	for _, m := range members {
+		resp.Members = append(resp.Members, &raftV1.Member{
+			Id:        m.ID,
+			PeerUrl:   m.PeerURL,
+			ClientUrl: m.ClientURL,
+			Learner:   m.Learner,
+		})
+	}
+	return resp, nil
+}
+
+func (c *controller) Status(ctx context.Context, request *raftV1.StatusRequest) (*raftV1.StatusResponse, error) {
+	st := c.node.status()
+	return &raftV1.StatusResponse{
+		Id:            st.ID,
+		Leader:        st.Lead,
+		State:         st.State,
+		Term:          st.Term,
+		CommitIndex:   st.CommitIndex,
+		AppliedIndex:  st.AppliedIndex,
+		SnapshotIndex: st.SnapshotIndex,
+		ApplyError:    st.ApplyError,
+	}, nil
+}
+
+func (c *controller) TransferLeadership(ctx context.Context, request *raftV1.TransferLeadershipRequest) (*raftV1.TransferLeadershipResponse, error) {
+	c.log.Debug("Transfer leadership request received", zap.Any("request", request))
+
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+	lead, err := c.node.transferLeadership(ctx, request.Target)
+	switch {
+	case errors.Is(err, errNoLeader):
+		return nil, status.Error(codes.Unavailable, "no leader available")
+	case errors.Is(err, errNotLeader):
+		return nil, notLeaderError(c.node.leader())
+	case errors.Is(err, errInvalidTransferee):
+		return nil, status.Errorf(codes.InvalidArgument, "node %d is not a voting follower", request.Target)
+	case err != nil:
+		c.log.Warn("Leadership not transferred", zap.Uint64("target", request.Target), zap.Error(err))
+		return nil, status.FromContextError(err).Err()
+	}
+	return &raftV1.TransferLeadershipResponse{Leader: lead}, nil
+}
+
+// proposeConfChange replies once the conf change has been applied
+func (c *controller) proposeConfChange(ctx context.Context, cc raftpb.ConfChange) (*raftV1.NodeResponse, error) {
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+	switch err := c.node.proposeConfChange(ctx, cc); {
+	case errors.Is(err, errInvalidConfChange):
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, raft.ErrProposalDropped):
+		return nil, status.Error(codes.Unavailable, "conf change dropped, no leader available")
+	case errors.Is(err, errStopped):
+		return nil, status.Error(codes.Unavailable, "node stopped")
+	case err != nil:
+		c.log.Warn("Conf change not applied", zap.Any("confChange", cc), zap.Error(err))
+		return nil, status.FromContextError(err).Err()
+	}
 	return &raftV1.NodeResponse{Ok: true}, nil
 }
+
+// memberContextOf validates the member being added and encodes it for the conf change
+func memberContextOf(request *raftV1.NodeRequest) ([]byte, error) {
+	if request.Id == 0 {
+		return nil, status.Error(codes.InvalidArgument, "node ID must not be zero")
+	}
+	member := memberContext{PeerURL: request.PeerUrl, ClientURL: request.ClientUrl}
+	if err := member.validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	data, err := member.marshal()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "couldn't encode member: %s", err)
+	}
+	return data, nil
+}
+
+// withDefaultTimeout applies defaultRequestTimeout unless the caller already set a deadline.
+func withDefaultTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, defaultRequestTimeout)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	apiV2 "github/m-wrona/raft-go/model/api/v2"
+)
+
+// authController serves management API of users and roles
+type authController struct {
+	log   *zap.Logger
+	store *kvstore
+}
+
+func newAuthController(log *zap.Logger, store *kvstore) *authController {
+	return &authController{
+		log:   log.With(zap.String("component", "authController")),
+		store: store,
+	}
+}
+
+func (c *authController) AuthEnable(ctx context.Context, request *apiV2.AuthEnableRequest) (*apiV2.AuthResponse, error) {
+	c.log.Info("Auth enable request received")
+	return c.change(ctx, authChange{Action: authEnable})
+}
+
+func (c *authController) AuthDisable(ctx context.Context, request *apiV2.AuthDisableRequest) (*apiV2.AuthResponse, error) {
+	c.log.Info("Auth disable request received")
+	return c.change(ctx, authChange{Action: authDisable})
+}
+
+func (c *authController) UserAdd(ctx context.Context, request *apiV2.UserAddRequest) (*apiV2.AuthResponse, error) {
+	c.log.Debug("User add request received", zap.String("user", request.Name))
+	if err := validateAuthName("user", authUserPrefix, request.Name); err != nil {
+		return nil, err
+	}
+	hash, err := c.hashPassword(request.Password)
+	if err != nil {
+		return nil, err
+	}
+	return c.change(ctx, authChange{Action: authUserAdd, User: request.Name, PasswordHash: hash})
+}
+
+func (c *authController) UserGet(ctx context.Context, request *apiV2.UserGetRequest) (*apiV2.UserGetResponse, error) {
+	roles, err := c.store.UserRoles(request.Name)
+	if err != nil {
+		return nil, c.authError(err)
+	}
+	return &apiV2.UserGetResponse{Roles: roles}, nil
+}
+
+func (c *authController) UserDelete(ctx context.Context, request *apiV2.UserDeleteRequest) (*apiV2.AuthResponse, error) {
+	c.log.Debug("User delete request received", zap.String("user", request.Name))
+	return c.change(ctx, authChange{Action: authUserDelete, User: request.Name})
+}
+
+func (c *authController) UserChangePassword(ctx context.Context, request *apiV2.UserChangePasswordRequest) (*apiV2.AuthResponse, error) {
+	c.log.Debug("User change password request received", zap.String("user", request.Name))
+	hash, err := c.hashPassword(request.Password)
+	if err != nil {
+		return nil, err
+	}
+	return c.change(ctx, authChange{Action: authUserChangePassword, User: request.Name, PasswordHash: hash})
+}
+
+func (c *authController) UserGrantRole(ctx context.Context, request *apiV2.UserGrantRoleRequest) (*apiV2.AuthResponse, error) {
+	c.log.Debug("User grant role request received", zap.String("user", request.User), zap.String("role", request.Role))
+	return c.change(ctx, authChange{Action: authUserGrantRole, User: request.User, Role: request.Role})
+}
+
+func (c *authController) UserRevokeRole(ctx context.Context, request *apiV2.UserRevokeRoleRequest) (*apiV2.AuthResponse, error) {
+	c.log.Debug("User revoke role request received", zap.String("user", request.User), zap.String("role", request.Role))
+	return c.change(ctx, authChange{Action: authUserRevokeRole, User: request.User, Role: request.Role})
+}
+
+func (c *authController) RoleAdd(ctx context.Context, request *apiV2.RoleAddRequest) (*apiV2.AuthResponse, error) {
+	c.log.Debug("Role add request received", zap.String("role", request.Name))
+	if err := validateAuthName("role", authRolePrefix, request.Name); err != nil {
+		return nil, err
+	}
+	return c.change(ctx, authChange{Action: authRoleAdd, Role: request.Name})
+}
+
+func (c *authController) RoleGet(ctx context.Context, request *apiV2.RoleGetRequest) (*apiV2.RoleGetResponse, error) {
+	perms, err := c.store.RolePermissions(request.Name)
+	if err != nil {
+		return nil, c.authError(err)
+	}
+	resp := &apiV2.RoleGetResponse{Permissions: make([]*apiV2.Permission, 0, len(perms))}
+	for _, p := range perms {
+		resp.Permissions = append(resp.Permissions, toPermission(p))
+	}
+	return resp, nil
+}
+
+func (c *authController) RoleDelete(ctx context.Context, request *apiV2.RoleDeleteRequest) (*apiV2.AuthResponse, error) {
+	c.log.Debug("Role delete request received", zap.String("role", request.Name))
+	return c.change(ctx, authChange{Action: authRoleDelete, Role: request.Name})
+}
+
+func (c *authController) RoleGrantPermission(ctx context.Context, request *apiV2.RoleGrantPermissionRequest) (*apiV2.AuthResponse, error) {
+	c.log.Debug("Role grant permission request received", zap.String("role", request.Name), zap.Any("permission", request.Permission))
+	perm, err := fromPermission(request.Permission)
+	if err != nil {
+		return nil, err
+	}
+	return c.change(ctx, authChange{Action: authRoleGrantPermission, Role: request.Name, Perm: perm})
+}
+
+func (c *authController) RoleRevokePermission(ctx context.Context, request *apiV2.RoleRevokePermissionRequest) (*apiV2.AuthResponse, error) {
+	c.log.Debug("Role revoke permission request received", zap.String("role", request.Name), zap.Any("permission", request.Permission))
+	perm, err := fromPermission(request.Permission)
+	if err != nil {
+		return nil, err
+	}
+	return c.change(ctx, authChange{Action: authRoleRevokePermission, Role: request.Name, Perm: perm})
+}
+
+func (c *authController) change(ctx context.Context, change authChange) (*apiV2.AuthResponse, error) {
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+	index, err := c.store.ChangeAuth(ctx, change)
+	if err != nil {
+		return nil, c.authError(err)
+	}
+	return &apiV2.AuthResponse{Index: index}, nil
+}
+
+func (c *authController) hashPassword(password string) ([]byte, error) {
+	if password == "" {
+		return nil, status.Error(codes.InvalidArgument, "password must not be empty")
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "couldn't hash password: %s", err)
+	}
+	return hash, nil
+}
+
+func (c *authController) authError(err error) error {
+	switch {
+	case errors.Is(err, errUserNotFound), errors.Is(err, errRoleNotFound),
+		errors.Is(err, errRoleNotGranted), errors.Is(err, errPermissionNotGranted):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, errUserExists), errors.Is(err, errRoleExists):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, errRootRequired), errors.Is(err, errRootRoleImmutable):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, errKeyTooLarge):
+		return status.Error(codes.InvalidArgument, err.Error())
+	default:
+		c.log.Warn("Auth change not committed", zap.Error(err))
+		return status.FromContextError(err).Err()
+	}
+}
+
+// validateAuthName checks the name fits into a key once it's prefixed to be stored
+func validateAuthName(kind, prefix, name string) error {
+	if name == "" {
+		return status.Errorf(codes.InvalidArgument, "%s name must not be empty", kind)
+	}
+	if max := maxKeySize - len(prefix); len(name) > max {
+		return status.Errorf(codes.InvalidArgument, "%s name exceeds %d bytes", kind, max)
+	}
+	return nil
+}
+
+func fromPermission(p *apiV2.Permission) (permission, error) {
+	if _, ok := apiV2.Permission_Type_name[int32(p.GetType())]; !ok {
+		return permission{}, status.Errorf(codes.InvalidArgument, "unknown permission type %d", p.GetType())
+	}
+	switch t := p.GetTarget().(type) {
+	case *apiV2.Permission_Method:
+		if !strings.HasPrefix(t.Method, "/") {
+			return permission{}, status.Error(codes.InvalidArgument, "method must be a full RPC name like /api.v2.KeyValueService/Put")
+		}
+		return permission{Method: t.Method}, nil
+	case *apiV2.Permission_KeyPrefix:
+		return permission{KeyPrefix: t.KeyPrefix, Type: permType(p.GetType())}, nil
+	default:
+		return permission{}, status.Error(codes.InvalidArgument, "permission must grant a method or a key prefix")
+	}
+}
+
+func toPermission(p permission) *apiV2.Permission {
+	if p.Method != "" {
+		return &apiV2.Permission{Target: &apiV2.Permission_Method{Method: p.Method}}
+	}
+	return &apiV2.Permission{
+		Target: &apiV2.Permission_KeyPrefix{KeyPrefix: p.KeyPrefix},
+		Type:   apiV2.Permission_Type(p.Type),
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	apiV2 "github/m-wrona/raft-go/model/api/v2"
+)
+
+const (
+	defaultRangeLimit = 100
+	maxRangeLimit     = 1000
+	maxTxnOps         = 128
+)
+
+// kvController serves the general purpose keyed API
+type kvController struct {
+	log   *zap.Logger
+	store *kvstore
+	node  *raftNode
+}
+
+func newKVController(log *zap.Logger, store *kvstore, node *raftNode) *kvController {
+	return &kvController{
+		log:   log.With(zap.String("component", "kvController")),
+		store: store,
+		node:  node,
+	}
+}
+
+func (c *kvController) Put(ctx context.Context, request *apiV2.PutRequest) (*apiV2.PutResponse, error) {
+	c.log.Debug("Put request received", zap.String("key", request.Key))
+	if request.Key == "" {
+		return nil, status.Error(codes.InvalidArgument, "key must not be empty")
+	}
+	if err := checkKeySize(request.Key, request.Lease); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := checkReserved(request.Key); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+	index, err := c.store.ProposeWithLease(ctx, request.Key, string(request.Value), request.Lease)
+	if errors.Is(err, errLeaseNotFound) {
+		return nil, status.Errorf(codes.NotFound, "lease %d not found", request.Lease)
+	} else if err != nil {
+		c.log.Warn("Value not committed", zap.String("key", request.Key), zap.Error(err))
+		return nil, status.FromContextError(err).Err()
+	}
+	return &apiV2.PutResponse{Index: index}, nil
+}
+
+func (c *kvController) Get(ctx context.Context, request *apiV2.GetRequest) (*apiV2.GetResponse, error) {
+	if request.Key == "" {
+		return nil, status.Error(codes.InvalidArgument, "key must not be empty")
+	}
+	if err := checkReserved(request.Key); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+	if err := c.linearizableRead(ctx); err != nil {
+		return nil, err
+	}
+
+	if kv, ok := c.store.Get(request.Key); ok {
+		return &apiV2.GetResponse{Value: []byte(kv.Val), ModIndex: kv.ModIndex}, nil
+	}
+	return nil, status.Errorf(codes.NotFound, "key %q not found", request.Key)
+}
+
+func (c *kvController) Delete(ctx context.Context, request *apiV2.DeleteRequest) (*apiV2.DeleteResponse, error) {
+	c.log.Debug("Delete request received", zap.String("key", request.Key))
+	if request.Key == "" {
+		return nil, status.Error(codes.InvalidArgument, "key must not be empty")
+	}
+	if err := checkReserved(request.Key); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+	index, err := c.store.Delete(ctx, request.Key)
+	if errors.Is(err, errKeyNotFound) {
+		return nil, status.Errorf(codes.NotFound, "key %q not found", request.Key)
+	} else if err != nil {
+		c.log.Warn("Deletion not committed", zap.String("key", request.Key), zap.Error(err))
+		return nil, status.FromContextError(err).Err()
+	}
+	return &apiV2.DeleteResponse{Index: index}, nil
+}
+
+func (c *kvController) Range(ctx context.Context, request *apiV2.RangeRequest) (*apiV2.RangeResponse, error) {
+	start, end := request.StartKey, request.EndKey
+	if request.Prefix != "" {
+		if start != "" || end != "" {
+			return nil, status.Error(codes.InvalidArgument, "prefix can't be combined with start and end keys")
+		}
+		start, end = request.Prefix, prefixEnd(request.Prefix)
+	}
+	if end != "" && end <= start {
+		return nil, status.Error(codes.InvalidArgument, "end key must be greater than start key")
+	}
+	if request.PageToken != "" {
+		next, err := base64.RawURLEncoding.DecodeString(request.PageToken)
+		if err != nil || string(next) < start || (end != "" && string(next) >= end) {
+			return nil, status.Error(codes.InvalidArgument, "page token doesn't belong to the range")
+		}
+		start = string(next)
+	}
+	limit := int(request.Limit)
+	if limit == 0 {
+		limit = defaultRangeLimit
+	} else if limit > maxRangeLimit {
+		limit = maxRangeLimit
+	}
+
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+	if err := c.linearizableRead(ctx); err != nil {
+		return nil, err
+	}
+
+	kvs, more := c.store.Range(start, end, limit)
+	resp := &apiV2.RangeResponse{Kvs: make([]*apiV2.KeyValue, 0, len(kvs))}
+	for _, kv := range kvs {
+		resp.Kvs = append(resp.Kvs, &apiV2.KeyValue{Key: kv.Key, Value: []byte(kv.Val), ModIndex: kv.ModIndex})
+	}
+	if more {
+		// smallest key greater than the last one returned
+		next := kvs[len(kvs)-1].Key + "\x00"
+		resp.NextPageToken = base64.RawURLEncoding.EncodeToString([]byte(next))
+	}
+	return resp, nil
+}
+
+func (c *kvController) Watch(request *apiV2.WatchRequest, stream apiV2.KeyValueService_WatchServer) error {
+	c.log.Debug("Watch request received", zap.Any("request", request))
+	if request.Key == "" && !request.Prefix {
+		return status.Error(codes.InvalidArgument, "key must not be empty")
+	}
+	if err := checkReserved(request.Key); err != nil {
+		return err
+	}
+
+	w, backlog, err := c.store.Watch(request.Key, request.Prefix, request.StartIndex)
+	if errors.Is(err, errCompacted) {
+		return status.Errorf(codes.OutOfRange, "start index %d has been compacted", request.StartIndex)
+	} else if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	defer c.store.CancelWatch(w)
+
+	if err := stream.Send(&apiV2.WatchResponse{Created: true}); err != nil {
+		return err
+	}
+	for _, ev := range backlog {
+		if err := stream.Send(&apiV2.WatchResponse{Events: []*apiV2.Event{toEvent(ev)}}); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case ev, ok := <-w.eventC:
+			if !ok {
+				return watchCanceled(w.err)
+			}
+			if err := stream.Send(&apiV2.WatchResponse{Events: []*apiV2.Event{toEvent(ev)}}); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		}
+	}
+}
+
+func (c *kvController) Txn(ctx context.Context, request *apiV2.TxnRequest) (*apiV2.TxnResponse, error) {
+	c.log.Debug("Txn request received", zap.Int("compares", len(request.Compare)),
+		zap.Int("success", len(request.Success)), zap.Int("failure", len(request.Failure)))
+	if len(request.Compare)+len(request.Success)+len(request.Failure) > maxTxnOps {
+		return nil, status.Errorf(codes.InvalidArgument, "transaction exceeds %d compares and ops", maxTxnOps)
+	}
+
+	t := txn{Compares: make([]compare, 0, len(request.Compare))}
+	for _, cmp := range request.Compare {
+		if cmp.Key == "" {
+			return nil, status.Error(codes.InvalidArgument, "compare key must not be empty")
+		}
+		if err := checkReserved(cmp.Key); err != nil {
+			return nil, err
+		}
+		if _, ok := apiV2.Compare_CompareResult_name[int32(cmp.Result)]; !ok {
+			return nil, status.Errorf(codes.InvalidArgument, "unknown compare result %d", cmp.Result)
+		}
+		if _, ok := apiV2.Compare_CompareTarget_name[int32(cmp.Target)]; !ok {
+			return nil, status.Errorf(codes.InvalidArgument, "unknown compare target %d", cmp.Target)
+		}
+		t.Compares = append(t.Compares, compare{
+			Key:      cmp.Key,
+			Target:   compareTarget(cmp.Target),
+			Result:   compareResult(cmp.Result),
+			Val:      string(cmp.Value),
+			ModIndex: cmp.ModIndex,
+		})
+	}
+	var err error
+	if t.Success, err = toTxnOps(request.Success); err != nil {
+		return nil, err
+	}
+	if t.Failure, err = toTxnOps(request.Failure); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+	index, succeeded, err := c.store.Txn(ctx, t)
+	if err != nil {
+		c.log.Warn("Transaction not committed", zap.Error(err))
+		return nil, status.FromContextError(err).Err()
+	}
+	return &apiV2.TxnResponse{Succeeded: succeeded, Index: index}, nil
+}
+
+// linearizableRead waits until the local store has caught up with the cluster so that
+// a read served afterwards can't return stale data.
+func (c *kvController) linearizableRead(ctx context.Context) error {
+	err := c.node.linearizableRead(ctx)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, errNoLeader), errors.Is(err, context.DeadlineExceeded):
+		c.log.Warn("Linearizable read not confirmed", zap.Error(err))
+		return status.Error(codes.Unavailable, "no quorum available to confirm read")
+	case errors.Is(err, context.Canceled):
+		return status.FromContextError(err).Err()
+	default:
+		c.log.Warn("Linearizable read failed", zap.Error(err))
+		return status.Error(codes.Unavailable, err.Error())
+	}
+}
+
+// prefixEnd returns the smallest key greater than all keys starting with the prefix,
+// or an empty key when there is no such key.
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return ""
+}
+
+// checkReserved rejects keys auth state is kept under
+func checkReserved(key string) error {
+	if reservedKey(key) {
+		return status.Errorf(codes.InvalidArgument, "key %q is reserved", key)
+	}
+	return nil
+}
+
+func toEvent(ev event) *apiV2.Event {
+	e := &apiV2.Event{Key: ev.Key, Index: ev.Index}
+	switch ev.Op {
+	case opPut:
+		e.Type = apiV2.Event_PUT
+		e.Value = []byte(ev.Val)
+	case opDelete:
+		e.Type = apiV2.Event_DELETE
+	}
+	return e
+}
+
+func watchCanceled(err error) error {
+	switch {
+	case errors.Is(err, errWatcherTooSlow):
+		return status.Error(codes.ResourceExhausted, "watcher fell behind, resume from the next index")
+	case errors.Is(err, errCompacted):
+		return status.Error(codes.OutOfRange, "store has been replaced by a snapshot, watched changes compacted")
+	case errors.Is(err, errShuttingDown):
+		return status.Error(codes.Unavailable, "node is shutting down, resume the watch on another node")
+	default:
+		return status.Error(codes.Canceled, "watch canceled")
+	}
+}
+
+func toTxnOps(requests []*apiV2.RequestOp) ([]txnOp, error) {
+	ops := make([]txnOp, 0, len(requests))
+	for _, r := range requests {
+		switch {
+		case r.GetPut() != nil:
+			if r.GetPut().Key == "" {
+				return nil, status.Error(codes.InvalidArgument, "key must not be empty")
+			}
+			if err := checkKeySize(r.GetPut().Key, r.GetPut().Lease); err != nil {
+				return nil, status.Error(codes.InvalidArgument, err.Error())
+			}
+			if err := checkReserved(r.GetPut().Key); err != nil {
+				return nil, err
+			}
+			if r.GetPut().Lease != 0 {
+				return nil, status.Error(codes.InvalidArgument, "leases can't be attached within transactions")
+			}
+			ops = append(ops, txnOp{Op: opPut, Key: r.GetPut().Key, Val: string(r.GetPut().Value)})
+		case r.GetDelete() != nil:
+			if r.GetDelete().Key == "" {
+				return nil, status.Error(codes.InvalidArgument, "key must not be empty")
+			}
+			if err := checkReserved(r.GetDelete().Key); err != nil {
+				return nil, err
+			}
+			ops = append(ops, txnOp{Op: opDelete, Key: r.GetDelete().Key})
+		default:
+			return nil, status.Error(codes.InvalidArgument, "transaction op must be a put or a delete")
+		}
+	}
+	return ops, nil
+}
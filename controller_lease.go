@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	apiV2 "github/m-wrona/raft-go/model/api/v2"
+)
+
+// maxLeaseTTL caps lease TTLs to one day
+const maxLeaseTTL = 24 * 60 * 60
+
+// leaseController serves lease management API
+type leaseController struct {
+	log   *zap.Logger
+	store *kvstore
+}
+
+func newLeaseController(log *zap.Logger, store *kvstore) *leaseController {
+	return &leaseController{
+		log:   log.With(zap.String("component", "leaseController")),
+		store: store,
+	}
+}
+
+func (c *leaseController) Grant(ctx context.Context, request *apiV2.LeaseGrantRequest) (*apiV2.LeaseGrantResponse, error) {
+	c.log.Debug("Lease grant request received", zap.Any("request", request))
+	if request.Ttl <= 0 || request.Ttl > maxLeaseTTL {
+		return nil, status.Errorf(codes.InvalidArgument, "lease TTL must be between 1 and %d seconds", maxLeaseTTL)
+	}
+	if request.Id < 0 {
+		return nil, status.Error(codes.InvalidArgument, "lease ID must not be negative")
+	}
+
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+	id, index, err := c.store.GrantLease(ctx, request.Id, request.Ttl)
+	if err != nil {
+		return nil, c.leaseError(request.Id, err)
+	}
+	return &apiV2.LeaseGrantResponse{Id: id, Ttl: request.Ttl, Index: index}, nil
+}
+
+func (c *leaseController) KeepAlive(ctx context.Context, request *apiV2.LeaseKeepAliveRequest) (*apiV2.LeaseKeepAliveResponse, error) {
+	c.log.Debug("Lease keep alive request received", zap.Int64("lease", request.Id))
+
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+	ttl, index, err := c.store.KeepAliveLease(ctx, request.Id)
+	if err != nil {
+		return nil, c.leaseError(request.Id, err)
+	}
+	return &apiV2.LeaseKeepAliveResponse{Ttl: ttl, Index: index}, nil
+}
+
+func (c *leaseController) Revoke(ctx context.Context, request *apiV2.LeaseRevokeRequest) (*apiV2.LeaseRevokeResponse, error) {
+	c.log.Debug("Lease revoke request received", zap.Int64("lease", request.Id))
+
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+	index, err := c.store.RevokeLease(ctx, request.Id)
+	if err != nil {
+		return nil, c.leaseError(request.Id, err)
+	}
+	return &apiV2.LeaseRevokeResponse{Index: index}, nil
+}
+
+func (c *leaseController) leaseError(id int64, err error) error {
+	switch {
+	case errors.Is(err, errLeaseNotFound):
+		return status.Errorf(codes.NotFound, "lease %d not found", id)
+	case errors.Is(err, errLeaseExists):
+		return status.Errorf(codes.AlreadyExists, "lease %d already exists", id)
+	default:
+		c.log.Warn("Lease change not committed", zap.Int64("lease", id), zap.Error(err))
+		return status.FromContextError(err).Err()
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.etcd.io/etcd/raft/v3"
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+const (
+	// forwardedHeader marks requests forwarded by a follower so they're never forwarded again
+	forwardedHeader = "x-raft-forwarded-by"
+	// noForwardHeader lets clients opt out of forwarding and get a NotLeader error instead
+	noForwardHeader = "x-raft-no-forward"
+
+	// notLeaderReason is the ErrorInfo reason of NotLeader errors, the leader hint is
+	// kept in leader_id and leader_url metadata
+	notLeaderReason = "NOT_LEADER"
+	notLeaderDomain = "raft-go"
+)
+
+// leaderOnlyMethods are the mutating RPCs followers hand over to the leader
+var leaderOnlyMethods = map[string]bool{
+	"/api.v1.KeyValueService/Set":    true,
+	"/api.v2.KeyValueService/Put":    true,
+	"/api.v2.KeyValueService/Delete": true,
+	"/api.v2.KeyValueService/Txn":    true,
+	"/api.v2.LeaseService/Grant":     true,
+	"/api.v2.LeaseService/KeepAlive": true,
+	"/api.v2.LeaseService/Revoke":    true,
+
+	"/api.v2.AuthService/AuthEnable":           true,
+	"/api.v2.AuthService/AuthDisable":          true,
+	"/api.v2.AuthService/UserAdd":              true,
+	"/api.v2.AuthService/UserDelete":           true,
+	"/api.v2.AuthService/UserChangePassword":   true,
+	"/api.v2.AuthService/UserGrantRole":        true,
+	"/api.v2.AuthService/UserRevokeRole":       true,
+	"/api.v2.AuthService/RoleAdd":              true,
+	"/api.v2.AuthService/RoleDelete":           true,
+	"/api.v2.AuthService/RoleGrantPermission":  true,
+	"/api.v2.AuthService/RoleRevokePermission": true,
+
+	"/api.v1.RaftService/Promote":            true,
+	"/api.v1.RaftService/TransferLeadership": true,
+}
+
+// forwarder routes mutating requests received by followers to the current leader
+type forwarder struct {
+	log   *zap.Logger
+	node  *raftNode
+	creds credentials.TransportCredentials // the leader is dialed with
+
+	mu    sync.Mutex
+	conns map[string]*grpc.ClientConn // leader connections by gRPC address
+}
+
+func newForwarder(log *zap.Logger, node *raftNode, creds credentials.TransportCredentials) *forwarder {
+	return &forwarder{
+		log:   log.With(zap.String("component", "forwarder")),
+		node:  node,
+		creds: creds,
+		conns: make(map[string]*grpc.ClientConn),
+	}
+}
+
+// UnaryInterceptor forwards leader only requests to the leader unless the client
+// asked for a NotLeader error. Requests are proposed locally if the leader's gRPC
+// address isn't known, in which case raft forwards the proposal itself. They're
+// refused once the node shuts down.
+func (f *forwarder) UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if !leaderOnlyMethods[info.FullMethod] {
+		return handler(ctx, req)
+	}
+	if f.node.isDraining() {
+		return nil, status.Error(codes.Unavailable, "node is shutting down")
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	if len(md.Get(forwardedHeader)) > 0 {
+		// leadership might have moved since, raft takes care of the proposal then
+		return handler(ctx, req)
+	}
+
+	lead, url := f.node.leader()
+	switch {
+	case lead == raft.None:
+		return nil, status.Error(codes.Unavailable, "no leader available")
+	case lead == uint64(f.node.id):
+		return handler(ctx, req)
+	case len(md.Get(noForwardHeader)) > 0:
+		return nil, notLeaderError(lead, url)
+	case url == "":
+		return handler(ctx, req)
+	}
+
+	f.log.Debug("Forwarding request to leader", zap.String("method", info.FullMethod), zap.Uint64("leader", lead), zap.String("url", url))
+	return f.forward(ctx, md, url, info.FullMethod, req)
+}
+
+func (f *forwarder) forward(ctx context.Context, md metadata.MD, url string, method string, req interface{}) (interface{}, error) {
+	reply, err := newReply(method)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "couldn't forward %s: %s", method, err)
+	}
+	conn, err := f.conn(url)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "couldn't reach leader at %s: %s", url, err)
+	}
+
+	md = md.Copy()
+	for _, key := range []string{":authority", "content-type", "user-agent"} {
+		md.Delete(key)
+	}
+	md.Set(forwardedHeader, strconv.Itoa(f.node.id))
+	ctx, cancel := withDefaultTimeout(metadata.NewOutgoingContext(ctx, md))
+	defer cancel()
+
+	if err := conn.Invoke(ctx, method, req, reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (f *forwarder) conn(url string) (*grpc.ClientConn, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if conn, ok := f.conns[url]; ok {
+		return conn, nil
+	}
+	conn, err := grpc.Dial(url, grpc.WithTransportCredentials(f.creds))
+	if err != nil {
+		return nil, err
+	}
+	f.conns[url] = conn
+	return conn, nil
+}
+
+// Close closes connections to the leaders.
+func (f *forwarder) Close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for url, conn := range f.conns {
+		conn.Close()
+		delete(f.conns, url)
+	}
+}
+
+// newReply creates an empty response message of the gRPC method
+func newReply(method string) (proto.Message, error) {
+	name := protoreflect.FullName(strings.ReplaceAll(strings.TrimPrefix(method, "/"), "/", "."))
+	desc, err := protoregistry.GlobalFiles.FindDescriptorByName(name)
+	if err != nil {
+		return nil, err
+	}
+	md, ok := desc.(protoreflect.MethodDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a method", name)
+	}
+	mt, err := protoregistry.GlobalTypes.FindMessageByName(md.Output().FullName())
+	if err != nil {
+		return nil, err
+	}
+	return mt.New().Interface(), nil
+}
+
+func notLeaderError(lead uint64, url string) error {
+	st := status.New(codes.FailedPrecondition, fmt.Sprintf("node is not the leader, current leader is %d", lead))
+	info := &errdetails.ErrorInfo{
+		Reason: notLeaderReason,
+		Domain: notLeaderDomain,
+		Metadata: map[string]string{
+			"leader_id":  strconv.FormatUint(lead, 10),
+			"leader_url": url,
+		},
+	}
+	if withInfo, err := st.WithDetails(info); err == nil {
+		st = withInfo
+	}
+	return st.Err()
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"net"
 	"os"
 	"os/signal"
@@ -10,11 +11,8 @@ import (
 	"google.golang.org/grpc"
 )
 
-type Config struct {
-	Network string `envconfig:"GRPC_NETWORK" default:"tcp"`
-	Address string `envconfig:"GRPC_ADDR" default:"0.0.0.0:8080"`
-}
-
+// startGRPC serves the gRPC API until the process is interrupted, the server is
+// stopped by gracefulShutdown afterwards.
 func startGRPC(server *grpc.Server, config Config, log *zap.Logger) {
 	go func() {
 		log.Debug("Starting GRPC...", zap.Any("cfg", config))
@@ -36,11 +34,36 @@ func startGRPC(server *grpc.Server, config Config, log *zap.Logger) {
 		syscall.SIGHUP,
 	)
 	log.Info("Server running - waiting for interrupt signal...")
-	<-c
-	log.Info("Stopping server...")
-	server.GracefulStop()
+	sig := <-c
+	log.Info("Interrupt signal received", zap.Stringer("signal", sig))
+}
+
+// stopGRPC stops the server once requests in flight are served, or cancels them when
+// the context is done first.
+func stopGRPC(ctx context.Context, server *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		server.Stop()
+		<-stopped
+	}
 }
 
-func NewGRPCServer(config *Config, log *zap.Logger) *grpc.Server {
-	return grpc.NewServer()
+// NewGRPCServer creates the gRPC server of the API, served over TLS if the config
+// has a certificate.
+func NewGRPCServer(config *Config, log *zap.Logger, opts ...grpc.ServerOption) (*grpc.Server, error) {
+	creds, err := grpcServerCredentials(config.grpcTLSInfo())
+	if err != nil {
+		return nil, err
+	}
+	if creds != nil {
+		log.Info("Serving GRPC over TLS", zap.String("cert", config.GRPCCertFile), zap.String("ca", config.GRPCTrustedCAFile))
+		opts = append(opts, creds)
+	}
+	return grpc.NewServer(opts...), nil
 }
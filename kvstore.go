@@ -1,119 +1,378 @@
 package main
 
 import (
-	"bytes"
-	"encoding/gob"
+	"bufio"
+	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"io"
 	"sync"
+	"time"
 
-	"go.etcd.io/etcd/raft/v3/raftpb"
-	"go.etcd.io/etcd/server/v3/etcdserver/api/snap"
+	"go.etcd.io/etcd/pkg/v3/idutil"
 )
 
 // a key-value store backed by raft
 type kvstore struct {
-	proposeC    chan<- string // channel for proposing updates
-	mu          sync.RWMutex
-	kvStore     map[string]string // current committed key-value pairs
-	snapshotter *snap.Snapshotter
+	proposer Proposer // replicates updates, set once the raft node is started
+	backend  backend  // committed key-value pairs and leases
+	mu       sync.RWMutex
+	leases   map[int64]*lease // deadlines of granted leases by ID
+	auth     authState        // users and roles kept under authKeyPrefix
+
+	passwords passwordCache
+
+	reqIDGen *idutil.Generator // request IDs of local proposals
+
+	watchMu  sync.Mutex
+	watchers map[*watcher]struct{}
+	notified uint64 // index of the last changes delivered to watchers
+	// catchUpEntries is the number of log entries raft keeps after a snapshot, the
+	// history kept under historyKeyPrefix keeps as many
+	catchUpEntries uint64
+}
+
+type op uint8
+
+const (
+	// opPut is the zero value so log entries written before commands were typed decode as puts
+	opPut op = iota
+	opDelete
+	opTxn
+	opLeaseGrant
+	opLeaseKeepAlive
+	opLeaseRevoke
+	opAuth
+)
+
+// command is a single update of the store replicated through raft
+type command struct {
+	ID    uint64 // request ID of the proposal, 0 if nobody waits for it
+	Op    op
+	Key   string
+	Val   string
+	Txn   *txn        // set for opTxn only
+	Lease int64       // lease of the put key or of lease ops
+	TTL   int64       // set for opLeaseGrant only
+	Auth  *authChange // set for opAuth only
 }
 
-type kv struct {
-	Key string
-	Val string
+// keyMeta is kept next to each value of the store
+type keyMeta struct {
+	ModIndex uint64 // raft log index of the last modification
+	Lease    int64  `json:",omitempty"` // lease the key is attached to
 }
 
-func newKVStore(snapshotter *snap.Snapshotter, proposeC chan<- string, commitC <-chan *commit, errorC <-chan error) *kvstore {
-	s := &kvstore{proposeC: proposeC, kvStore: make(map[string]string), snapshotter: snapshotter}
-	snapshot, err := s.loadSnapshot()
-	if err != nil {
-		log.Panic(err)
+// keyValue is a single pair returned by reads
+type keyValue struct {
+	Key      string
+	Val      string
+	ModIndex uint64
+}
+
+// applyResult is handed over to the proposer once its command got applied
+type applyResult struct {
+	index     uint64 // raft log index the command was committed at
+	succeeded bool   // whether compares of a transaction held
+	ttl       int64  // TTL of the granted or refreshed lease
+	err       error
+}
+
+// snapshotState is the JSON snapshot representation of the store taken before
+// the binary format
+type snapshotState struct {
+	KV     map[string]string    `json:"kv"`
+	Meta   map[string]keyMeta   `json:"meta"`
+	Leases map[int64]leaseState `json:"leases,omitempty"`
+}
+
+var (
+	errKeyNotFound = errors.New("key not found")
+	// errKeyTooLarge is returned for updates of keys backends can't store. It's checked
+	// when applying, so all replicas refuse the update whatever backend they use.
+	errKeyTooLarge = errors.New("key too large")
+)
+
+// checkKeySize verifies the key fits into backends, attached to the lease if it's set
+func checkKeySize(key string, lease int64) error {
+	max := maxKeySize
+	if lease != 0 {
+		max = maxLeasedKeySize
 	}
-	if snapshot != nil {
-		log.Printf("loading snapshot at term %d and index %d", snapshot.Metadata.Term, snapshot.Metadata.Index)
-		if err := s.recoverFromSnapshot(snapshot.Data); err != nil {
-			log.Panic(err)
-		}
+	if len(key) > max {
+		return fmt.Errorf("%w: %d bytes exceed %d", errKeyTooLarge, len(key), max)
+	}
+	return nil
+}
+
+// newKVStore creates a store kept by the backend, updates the backend lacks are
+// replayed by the raft node the store is replicated by.
+func newKVStore(id int, b backend) (*kvstore, error) {
+	s := &kvstore{
+		backend:        b,
+		reqIDGen:       idutil.NewGenerator(uint16(id), time.Now()),
+		catchUpEntries: defaultRaftConfig().SnapshotCatchUpEntries,
+	}
+	if err := s.loadLeases(); err != nil {
+		return nil, err
+	}
+	if err := s.loadAuth(); err != nil {
+		return nil, err
+	}
+	if err := s.initHistory(b.appliedIndex()); err != nil {
+		return nil, err
 	}
-	// read commits from raft into kvStore map until error
-	go s.readCommits(commitC, errorC)
-	return s
+	s.resetHistory(b.appliedIndex())
+	return s, nil
 }
 
 func (s *kvstore) Lookup(key string) (string, bool) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	v, ok := s.kvStore[key]
-	return v, ok
+	kv, ok := s.Get(key)
+	return kv.Val, ok
 }
 
-func (s *kvstore) Propose(k string, v string) {
-	var buf bytes.Buffer
-	if err := gob.NewEncoder(&buf).Encode(kv{k, v}); err != nil {
-		log.Fatal(err)
-	}
-	s.proposeC <- buf.String()
+// Get returns the pair together with its metadata.
+func (s *kvstore) Get(key string) (keyValue, bool) {
+	kv := keyValue{Key: key}
+	var ok bool
+	s.backend.view(func(tx backendTx) error {
+		var meta keyMeta
+		kv.Val, meta, ok = tx.get(key)
+		kv.ModIndex = meta.ModIndex
+		return nil
+	})
+	return kv, ok
 }
 
-func (s *kvstore) readCommits(commitC <-chan *commit, errorC <-chan error) {
-	for commit := range commitC {
-		if commit == nil {
-			// signaled to load snapshot
-			snapshot, err := s.loadSnapshot()
-			if err != nil {
-				log.Panic(err)
+// Range returns up to limit pairs with keys in [start, end) in ascending key order.
+// An empty end means there is no upper bound. The returned flag reports whether
+// more keys are left in the range. Reserved keys are skipped.
+func (s *kvstore) Range(start, end string, limit int) ([]keyValue, bool) {
+	kvs := make([]keyValue, 0, limit)
+	more := false
+	s.backend.view(func(tx backendTx) error {
+		tx.ascend(start, end, func(key, val string, meta keyMeta) bool {
+			if reservedKey(key) {
+				return true
 			}
-			if snapshot != nil {
-				log.Printf("loading snapshot at term %d and index %d", snapshot.Metadata.Term, snapshot.Metadata.Index)
-				if err := s.recoverFromSnapshot(snapshot.Data); err != nil {
-					log.Panic(err)
-				}
+			if len(kvs) == limit {
+				more = true
+				return false
 			}
+			kvs = append(kvs, keyValue{key, val, meta.ModIndex})
+			return true
+		})
+		return nil
+	})
+	return kvs, more
+}
+
+// AppliedIndex returns the raft log index the store has applied updates up to.
+func (s *kvstore) AppliedIndex() uint64 {
+	return s.backend.appliedIndex()
+}
+
+// Close releases the backend.
+func (s *kvstore) Close() error {
+	return s.backend.close()
+}
+
+// Propose replicates the key-value pair through raft and blocks until it
+// has been committed and applied to the store. It returns the raft log index
+// the pair was committed at.
+func (s *kvstore) Propose(ctx context.Context, k string, v string) (uint64, error) {
+	return s.ProposeWithLease(ctx, k, v, 0)
+}
+
+// ProposeWithLease works like Propose but attaches the key to the lease, so it gets
+// deleted once the lease expires. It fails with errLeaseNotFound if the lease doesn't exist.
+func (s *kvstore) ProposeWithLease(ctx context.Context, k string, v string, lease int64) (uint64, error) {
+	result, err := s.propose(ctx, command{Op: opPut, Key: k, Val: v, Lease: lease})
+	return result.index, err
+}
+
+// Delete replicates removal of the key through raft and blocks until it has been
+// applied. It fails with errKeyNotFound if the key wasn't present at that point.
+func (s *kvstore) Delete(ctx context.Context, k string) (uint64, error) {
+	result, err := s.propose(ctx, command{Op: opDelete, Key: k})
+	return result.index, err
+}
+
+func (s *kvstore) propose(ctx context.Context, cmd command) (applyResult, error) {
+	cmd.ID = s.reqIDGen.Next()
+	data, err := encodeCommand(cmd)
+	if err != nil {
+		return applyResult{}, err
+	}
+	r, err := s.proposer.Propose(ctx, cmd.ID, data)
+	result, _ := r.Value.(applyResult)
+	return result, err
+}
+
+// Apply applies committed commands to the store and returns their results. The
+// changes are committed to the backend at once, entries the backend kept before a
+// restart are skipped. It fails without changing the store if an entry can't be
+// decoded or is a command this replica doesn't support, applying the entries after
+// it would let the replica diverge from the ones understanding it. It also fails if
+// the backend can't commit the changes.
+func (s *kvstore) Apply(entries []Entry) ([]Result, error) {
+	applied := s.backend.appliedIndex()
+	if len(entries) == 0 || entries[len(entries)-1].Index <= applied {
+		return nil, nil
+	}
+
+	cmds := make([]command, len(entries))
+	for i, e := range entries {
+		if e.Index <= applied {
 			continue
 		}
+		cmd, err := decodeCommand(e.Data)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode entry at index %d, a newer version of the replica may be required: %w", e.Index, err)
+		}
+		cmds[i] = cmd
+	}
 
-		for _, data := range commit.data {
-			var dataKv kv
-			dec := gob.NewDecoder(bytes.NewBufferString(data))
-			if err := dec.Decode(&dataKv); err != nil {
-				log.Fatalf("raftexample: could not decode message (%v)", err)
+	results := make([]Result, 0, len(entries))
+	var events []event
+	s.mu.Lock()
+	err := s.backend.update(entries[len(entries)-1].Index, func(tx backendTx) error {
+		for i, e := range entries {
+			if e.Index <= applied {
+				continue
+			}
+			evs, result := s.apply(tx, e.Index, cmds[i])
+			if errors.Is(result.err, errUnsupportedCommand) {
+				return fmt.Errorf("cannot apply entry at index %d: %w", e.Index, result.err)
 			}
-			s.mu.Lock()
-			s.kvStore[dataKv.Key] = dataKv.Val
-			s.mu.Unlock()
+			recordHistory(tx, e.Index, evs)
+			events = append(events, evs...)
+			results = append(results, Result{ID: cmds[i].ID, Index: e.Index, Value: result, Err: result.err})
 		}
-		close(commit.applyDoneC)
+		s.compactHistory(tx, entries[len(entries)-1].Index)
+		return nil
+	})
+	s.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("cannot commit entries up to index %d: %w", entries[len(entries)-1].Index, err)
 	}
-	if err, ok := <-errorC; ok {
-		log.Fatal(err)
+	s.notify(entries[len(entries)-1].Index, events)
+	return results, nil
+}
+
+// apply applies the command committed at the index and returns the resulting changes.
+// It has to be called with the store lock held.
+func (s *kvstore) apply(tx backendTx, index uint64, cmd command) ([]event, applyResult) {
+	result := applyResult{index: index}
+	switch cmd.Op {
+	case opPut:
+		if _, ok := s.leases[cmd.Lease]; cmd.Lease != 0 && !ok {
+			result.err = errLeaseNotFound
+			return nil, result
+		}
+		if result.err = checkKeySize(cmd.Key, cmd.Lease); result.err != nil {
+			return nil, result
+		}
+		return []event{s.put(tx, index, cmd.Key, cmd.Val, cmd.Lease)}, result
+	case opDelete:
+		ev, ok := s.delete(tx, index, cmd.Key)
+		if !ok {
+			result.err = errKeyNotFound
+			return nil, result
+		}
+		return []event{ev}, result
+	case opTxn:
+		if result.err = cmd.Txn.checkKeySizes(); result.err != nil {
+			return nil, result
+		}
+		var events []event
+		events, result.succeeded = s.applyTxn(tx, index, cmd.Txn)
+		return events, result
+	case opLeaseGrant, opLeaseKeepAlive, opLeaseRevoke:
+		return s.applyLease(tx, index, cmd, &result), result
+	case opAuth:
+		result.err = s.applyAuth(tx, index, cmd.Auth)
+		return nil, result
+	default:
+		result.err = fmt.Errorf("%w: op %d", errUnsupportedCommand, cmd.Op)
+		return nil, result
 	}
 }
 
-func (s *kvstore) getSnapshot() ([]byte, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	return json.Marshal(s.kvStore)
+func (s *kvstore) put(tx backendTx, index uint64, key, val string, lease int64) event {
+	tx.put(key, val, keyMeta{ModIndex: index, Lease: lease})
+	return event{Op: opPut, Key: key, Val: val, Index: index}
 }
 
-func (s *kvstore) loadSnapshot() (*raftpb.Snapshot, error) {
-	snapshot, err := s.snapshotter.Load()
-	if err == snap.ErrNoSnapshot {
-		return nil, nil
+func (s *kvstore) delete(tx backendTx, index uint64, key string) (event, bool) {
+	if _, _, ok := tx.get(key); !ok {
+		return event{}, false
+	}
+	tx.delete(key)
+	return event{Op: opDelete, Key: key, Index: index}, true
+}
+
+// Snapshot captures the store and returns a function writing it in the snapshot
+// format of its backend.
+func (s *kvstore) Snapshot() (func(w io.Writer) error, error) {
+	return s.backend.snapshot()
+}
+
+// Restore replaces the store with the snapshot taken at the index. Snapshots of
+// any backend can be restored.
+func (s *kvstore) Restore(r io.Reader, index uint64) error {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(snapshotMagic))
+	var err error
+	switch string(magic) {
+	case snapshotMagic:
+		err = s.backend.reset(index, func(tx backendTx) error {
+			_, err := readSnapshot(br, tx)
+			return err
+		})
+	case dbSnapshotMagic:
+		err = s.backend.restoreDB(br, index)
+	default:
+		var data []byte
+		if data, err = io.ReadAll(br); err == nil {
+			err = s.backend.reset(index, func(tx backendTx) error {
+				return recoverFromSnapshot(data, tx)
+			})
+		}
 	}
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if err := s.loadLeases(); err != nil {
+		return err
 	}
-	return snapshot, nil
+	if err := s.loadAuth(); err != nil {
+		return err
+	}
+	if err := s.initHistory(index); err != nil {
+		return err
+	}
+	s.resetHistory(index)
+	return nil
 }
 
-func (s *kvstore) recoverFromSnapshot(snapshot []byte) error {
-	var store map[string]string
-	if err := json.Unmarshal(snapshot, &store); err != nil {
-		return err
+// recoverFromSnapshot writes snapshots taken before the binary format, which are
+// JSON encoded, to the transaction.
+func recoverFromSnapshot(snapshot []byte, tx backendTx) error {
+	var state snapshotState
+	if err := json.Unmarshal(snapshot, &state); err != nil || state.KV == nil {
+		// snapshots taken before metadata was kept hold the plain key-value map
+		if err := json.Unmarshal(snapshot, &state.KV); err != nil {
+			return err
+		}
+		state.Meta = nil
+	}
+	for id, l := range state.Leases {
+		tx.putLease(id, l.TTL)
+	}
+	for k, v := range state.KV {
+		tx.put(k, v, state.Meta[k])
 	}
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.kvStore = store
 	return nil
 }
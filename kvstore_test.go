@@ -1,33 +1,897 @@
 package main
 
 import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
+	"strings"
 	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	storeV1 "github/m-wrona/raft-go/model/store/v1"
 )
 
 func Test_KVStore_snapshot(t *testing.T) {
 	tm := map[string]string{"foo": "bar"}
-	s := &kvstore{kvStore: tm}
+	s := newTestKVStore()
+	testApply(s, 1, command{Op: opPut, Key: "foo", Val: "bar"})
 
 	v, _ := s.Lookup("foo")
 	if v != "bar" {
 		t.Fatalf("foo has unexpected value, got %s", v)
 	}
 
-	data, err := s.getSnapshot()
+	data, err := takeSnapshot(s)
 	if err != nil {
 		t.Fatal(err)
 	}
-	s.kvStore = nil
+	s = newTestKVStore()
 
-	if err := s.recoverFromSnapshot(data); err != nil {
+	if err := s.Restore(bytes.NewReader(data), 0); err != nil {
 		t.Fatal(err)
 	}
 	v, _ = s.Lookup("foo")
 	if v != "bar" {
 		t.Fatalf("foo has unexpected value, got %s", v)
 	}
-	if !reflect.DeepEqual(s.kvStore, tm) {
-		t.Fatalf("store expected %+v, got %+v", tm, s.kvStore)
+	if !reflect.DeepEqual(contents(s), tm) {
+		t.Fatalf("store expected %+v, got %+v", tm, contents(s))
+	}
+}
+
+func Test_KVStore_delete(t *testing.T) {
+	s := newTestKVStore()
+
+	var index uint64
+	applyCommit := func(entries ...interface{}) []Result {
+		var ents []Entry
+		for _, e := range entries {
+			var buf bytes.Buffer
+			if err := gob.NewEncoder(&buf).Encode(e); err != nil {
+				t.Fatal(err)
+			}
+			index++
+			ents = append(ents, Entry{Index: index, Data: buf.Bytes()})
+		}
+		results, err := s.Apply(ents)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return results
+	}
+
+	// entries proposed before commands were typed carry only key & value
+	legacy := struct{ Key, Val string }{"legacy", "1"}
+	applyCommit(legacy, command{Op: opPut, Key: "foo", Val: "bar"})
+	if v, _ := s.Lookup("legacy"); v != "1" {
+		t.Fatalf("legacy has unexpected value, got %s", v)
+	}
+
+	results := applyCommit(
+		command{ID: 1, Op: opDelete, Key: "foo"},
+		command{ID: 2, Op: opDelete, Key: "foo"},
+		command{Op: opDelete, Key: "legacy"},
+	)
+	if _, ok := s.Lookup("foo"); ok {
+		t.Fatalf("foo hasn't been deleted")
+	}
+	if r := results[0]; r.ID != 1 || r.Err != nil || r.Index != 3 {
+		t.Fatalf("unexpected result of deletion: %+v", r)
+	}
+	if r := results[1]; r.ID != 2 || r.Err != errKeyNotFound {
+		t.Fatalf("expected key not found, got %+v", r)
+	}
+
+	data, err := takeSnapshot(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Restore(bytes.NewReader(data), 0); err != nil {
+		t.Fatal(err)
+	}
+	if kvs := contents(s); len(kvs) != 0 {
+		t.Fatalf("deleted keys recovered from snapshot: %+v", kvs)
+	}
+	applyCommit(command{Op: opPut, Key: "foo", Val: "baz"})
+	if v, _ := s.Lookup("foo"); v != "baz" {
+		t.Fatalf("foo has unexpected value, got %s", v)
+	}
+}
+
+func Test_KVStore_range(t *testing.T) {
+	s := newTestKVStore()
+	for i, k := range []string{"sensors/2/temp", "sensors/1/temp", "sensors/10/temp", "devices/1", "sensors/1/hum"} {
+		if _, result := testApply(s, uint64(i+1), command{Op: opPut, Key: k, Val: k}); result.err != nil {
+			t.Fatal(result.err)
+		}
+	}
+	if _, result := testApply(s, 6, command{Op: opDelete, Key: "sensors/2/temp"}); result.err != nil {
+		t.Fatal(result.err)
+	}
+
+	kvs, more := s.Range("sensors/", prefixEnd("sensors/"), 10)
+	want := []keyValue{
+		{"sensors/1/hum", "sensors/1/hum", 5},
+		{"sensors/1/temp", "sensors/1/temp", 2},
+		{"sensors/10/temp", "sensors/10/temp", 3},
+	}
+	if more || !reflect.DeepEqual(kvs, want) {
+		t.Fatalf("range expected %+v, got %+v (more: %v)", want, kvs, more)
+	}
+
+	kvs, more = s.Range("", "", 2)
+	want = []keyValue{{"devices/1", "devices/1", 4}, {"sensors/1/hum", "sensors/1/hum", 5}}
+	if !more || !reflect.DeepEqual(kvs, want) {
+		t.Fatalf("range expected %+v, got %+v (more: %v)", want, kvs, more)
+	}
+
+	data, err := takeSnapshot(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Restore(bytes.NewReader(data), 0); err != nil {
+		t.Fatal(err)
+	}
+	kvs, _ = s.Range("sensors/10", "", 10)
+	want = []keyValue{{"sensors/10/temp", "sensors/10/temp", 3}}
+	if !reflect.DeepEqual(kvs, want) {
+		t.Fatalf("range after snapshot expected %+v, got %+v", want, kvs)
+	}
+}
+
+func Test_KVStore_prefixEnd(t *testing.T) {
+	for prefix, want := range map[string]string{
+		"a":            "b",
+		"sensors/":     "sensors0",
+		"a\xff":        "b",
+		"\xff\xff":     "",
+		"sensors/\x00": "sensors/\x01",
+	} {
+		if got := prefixEnd(prefix); got != want {
+			t.Fatalf("prefix end of %q expected %q, got %q", prefix, want, got)
+		}
+	}
+}
+
+func Test_KVStore_watch(t *testing.T) {
+	s := newTestKVStore()
+	s.catchUpEntries = 2
+	apply := func(index uint64, cmd command) {
+		data, err := encodeCommand(cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results, err := s.Apply([]Entry{{Index: index, Data: data}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if results[0].Err != nil {
+			t.Fatal(results[0].Err)
+		}
+	}
+
+	apply(1, command{Op: opPut, Key: "sensors/1/temp", Val: "20"})
+	apply(2, command{Op: opPut, Key: "devices/1", Val: "on"})
+
+	live, backlog, err := s.Watch("sensors/", true, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backlog) != 0 {
+		t.Fatalf("watch from current index returned history: %+v", backlog)
+	}
+	resumed, backlog, err := s.Watch("sensors/", true, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []event{{opPut, "sensors/1/temp", "20", 1}}; !reflect.DeepEqual(backlog, want) {
+		t.Fatalf("backlog expected %+v, got %+v", want, backlog)
+	}
+
+	apply(3, command{Op: opPut, Key: "sensors/2/temp", Val: "21"})
+	apply(4, command{Op: opDelete, Key: "sensors/1/temp"})
+	want := []event{{opPut, "sensors/2/temp", "21", 3}, {opDelete, "sensors/1/temp", "", 4}}
+	for _, w := range []*watcher{live, resumed} {
+		for _, wantEv := range want {
+			if ev := <-w.eventC; !reflect.DeepEqual(ev, wantEv) {
+				t.Fatalf("event expected %+v, got %+v", wantEv, ev)
+			}
+		}
+	}
+
+	// history keeps changes of the last catch-up entries only
+	if _, _, err := s.Watch("sensors/", true, 2); err != errCompacted {
+		t.Fatalf("expected compacted error, got %v", err)
+	}
+	_, backlog, err = s.Watch("sensors/1/temp", false, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []event{{opDelete, "sensors/1/temp", "", 4}}; !reflect.DeepEqual(backlog, want) {
+		t.Fatalf("backlog expected %+v, got %+v", want, backlog)
+	}
+
+	// history is kept by snapshots, watchers are canceled on restore and may resume
+	data, err := takeSnapshot(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := newTestKVStore()
+	restoredWatcher, _, err := restored.Watch("sensors/", true, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.Restore(bytes.NewReader(data), 4); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-restoredWatcher.eventC; ok || restoredWatcher.err != errCompacted {
+		t.Fatalf("expected watcher to be canceled on restore, got %v", restoredWatcher.err)
+	}
+	if _, _, err := restored.Watch("sensors/", true, 2); err != errCompacted {
+		t.Fatalf("expected compacted error after restore, got %v", err)
+	}
+	_, backlog, err = restored.Watch("sensors/", true, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(backlog, want) {
+		t.Fatalf("backlog after restore expected %+v, got %+v", want, backlog)
+	}
+
+	s.CancelWatch(resumed)
+	for i := 0; i <= watcherBufferSize; i++ {
+		apply(uint64(5+i), command{Op: opPut, Key: "sensors/3/temp", Val: "22"})
+	}
+	if _, ok := <-resumed.eventC; ok || resumed.err != nil {
+		t.Fatalf("canceled watcher received events")
+	}
+	for range live.eventC {
+		// drain buffered events until the watcher gets canceled
+	}
+	if live.err != errWatcherTooSlow {
+		t.Fatalf("expected slow watcher to be canceled, got %v", live.err)
+	}
+}
+
+func Test_KVStore_txn(t *testing.T) {
+	s := newTestKVStore()
+	testApply(s, 1, command{Op: opPut, Key: "counter", Val: "1"})
+	testApply(s, 2, command{Op: opPut, Key: "owner", Val: "device-1"})
+
+	// compare-and-swap on value and modification index
+	events, result := testApply(s, 3, command{Op: opTxn, Txn: &txn{
+		Compares: []compare{
+			{Key: "counter", Target: compareValue, Result: compareEqual, Val: "1"},
+			{Key: "owner", Target: compareModIndex, Result: compareEqual, ModIndex: 2},
+		},
+		Success: []txnOp{{Op: opPut, Key: "counter", Val: "2"}, {Op: opDelete, Key: "owner"}},
+		Failure: []txnOp{{Op: opPut, Key: "conflict", Val: "1"}},
+	}})
+	if !result.succeeded || result.err != nil {
+		t.Fatalf("transaction expected to succeed, got %+v", result)
+	}
+	wantEvents := []event{{opPut, "counter", "2", 3}, {opDelete, "owner", "", 3}}
+	if !reflect.DeepEqual(events, wantEvents) {
+		t.Fatalf("events expected %+v, got %+v", wantEvents, events)
+	}
+	if kv, _ := s.Get("counter"); kv.Val != "2" || kv.ModIndex != 3 {
+		t.Fatalf("counter has unexpected state %+v", kv)
+	}
+	if _, ok := s.Get("owner"); ok {
+		t.Fatalf("owner hasn't been deleted")
+	}
+
+	// stale compare runs failure ops only
+	_, result = testApply(s, 4, command{Op: opTxn, Txn: &txn{
+		Compares: []compare{
+			{Key: "counter", Target: compareModIndex, Result: compareLess, ModIndex: 3},
+		},
+		Success: []txnOp{{Op: opPut, Key: "counter", Val: "3"}},
+		Failure: []txnOp{{Op: opPut, Key: "conflict", Val: "1"}, {Op: opDelete, Key: "missing"}},
+	}})
+	if result.succeeded || result.err != nil {
+		t.Fatalf("transaction expected to fail, got %+v", result)
+	}
+	if v, _ := s.Lookup("counter"); v != "2" {
+		t.Fatalf("counter has unexpected value, got %s", v)
+	}
+	if v, _ := s.Lookup("conflict"); v != "1" {
+		t.Fatalf("conflict has unexpected value, got %s", v)
+	}
+
+	// missing keys have zero modification index and no value
+	_, result = testApply(s, 5, command{Op: opTxn, Txn: &txn{
+		Compares: []compare{{Key: "lock", Target: compareModIndex, Result: compareEqual, ModIndex: 0}},
+		Success:  []txnOp{{Op: opPut, Key: "lock", Val: "device-2"}},
+	}})
+	if !result.succeeded {
+		t.Fatalf("transaction on missing key expected to succeed")
+	}
+	_, result = testApply(s, 6, command{Op: opTxn, Txn: &txn{
+		Compares: []compare{{Key: "missing", Target: compareValue, Result: compareNotEqual, Val: "x"}},
+	}})
+	if result.succeeded {
+		t.Fatalf("value compare on missing key expected to fail")
+	}
+
+	data, err := takeSnapshot(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Restore(bytes.NewReader(data), 0); err != nil {
+		t.Fatal(err)
+	}
+	if kv, _ := s.Get("lock"); kv.ModIndex != 5 {
+		t.Fatalf("modification index not recovered from snapshot, got %+v", kv)
+	}
+}
+
+func Test_KVStore_legacySnapshot(t *testing.T) {
+	s := newTestKVStore()
+	if err := s.Restore(bytes.NewReader([]byte(`{"foo":"bar","kv":"baz"}`)), 0); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"foo": "bar", "kv": "baz"}
+	if !reflect.DeepEqual(contents(s), want) {
+		t.Fatalf("store expected %+v, got %+v", want, contents(s))
+	}
+	testApply(s, 1, command{Op: opPut, Key: "foo", Val: "qux"})
+	if kv, _ := s.Get("foo"); kv.ModIndex != 1 {
+		t.Fatalf("unexpected state of foo %+v", kv)
+	}
+}
+
+func newTestKVStore() *kvstore {
+	s, err := newKVStore(1, newMemoryBackend(""))
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func Test_KVStore_lease(t *testing.T) {
+	s := newTestKVStore()
+	testApply(s, 1, command{Op: opLeaseGrant, Lease: 7, TTL: 10})
+	if _, result := testApply(s, 2, command{Op: opLeaseGrant, Lease: 7, TTL: 10}); result.err != errLeaseExists {
+		t.Fatalf("expected lease to exist, got %+v", result)
+	}
+	if _, result := testApply(s, 3, command{Op: opPut, Key: "orphan", Val: "1", Lease: 8}); result.err != errLeaseNotFound {
+		t.Fatalf("expected missing lease, got %+v", result)
+	}
+	testApply(s, 4, command{Op: opPut, Key: "devices/1/presence", Val: "online", Lease: 7})
+	testApply(s, 5, command{Op: opPut, Key: "devices/2/presence", Val: "online", Lease: 7})
+	testApply(s, 6, command{Op: opPut, Key: "devices/3/presence", Val: "online", Lease: 7})
+	// overwriting without a lease detaches the key
+	testApply(s, 7, command{Op: opPut, Key: "devices/3/presence", Val: "static"})
+
+	data, err := takeSnapshot(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := newTestKVStore()
+	if err := restored.Restore(bytes.NewReader(data), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []*kvstore{s, restored} {
+		if _, result := testApply(s, 8, command{Op: opLeaseKeepAlive, Lease: 7}); result.err != nil || result.ttl != 10 {
+			t.Fatalf("unexpected keep alive result %+v", result)
+		}
+		events, result := testApply(s, 9, command{Op: opLeaseRevoke, Lease: 7})
+		if result.err != nil || len(events) != 2 {
+			t.Fatalf("unexpected revoke result %+v, events: %+v", result, events)
+		}
+		want := map[string]string{"devices/3/presence": "static"}
+		if !reflect.DeepEqual(contents(s), want) {
+			t.Fatalf("store expected %+v, got %+v", want, contents(s))
+		}
+		if _, result := testApply(s, 10, command{Op: opLeaseKeepAlive, Lease: 7}); result.err != errLeaseNotFound {
+			t.Fatalf("expected revoked lease to be missing, got %+v", result)
+		}
+	}
+}
+
+func Test_KVStore_auth(t *testing.T) {
+	s := newTestKVStore()
+	changes := []struct {
+		change authChange
+		err    error
+	}{
+		{authChange{Action: authEnable}, errRootRequired},
+		{authChange{Action: authUserAdd, User: rootUser, PasswordHash: []byte("root")}, nil},
+		{authChange{Action: authUserGrantRole, User: rootUser, Role: rootRole}, nil},
+		{authChange{Action: authRoleAdd, Role: rootRole}, errRoleExists},
+		{authChange{Action: authRoleAdd, Role: "sensors"}, nil},
+		{authChange{Action: authRoleGrantPermission, Role: "sensors", Perm: permission{Method: "/api.v2.KeyValueService/*"}}, nil},
+		{authChange{Action: authRoleGrantPermission, Role: "sensors", Perm: permission{KeyPrefix: "sensors/", Type: permWrite}}, nil},
+		// grants of the same prefix replace each other
+		{authChange{Action: authRoleGrantPermission, Role: "sensors", Perm: permission{KeyPrefix: "sensors/", Type: permReadWrite}}, nil},
+		{authChange{Action: authRoleGrantPermission, Role: rootRole, Perm: permission{KeyPrefix: ""}}, errRootRoleImmutable},
+		{authChange{Action: authUserAdd, User: "device", PasswordHash: []byte("device")}, nil},
+		{authChange{Action: authUserGrantRole, User: "device", Role: "actuators"}, errRoleNotFound},
+		{authChange{Action: authUserGrantRole, User: "device", Role: "sensors"}, nil},
+		{authChange{Action: authEnable}, nil},
+		{authChange{Action: authUserDelete, User: rootUser}, errRootRequired},
+		{authChange{Action: authUserRevokeRole, User: rootUser, Role: rootRole}, errRootRequired},
+	}
+	for i, c := range changes {
+		if _, result := testApply(s, uint64(i+1), command{Op: opAuth, Auth: &c.change}); result.err != c.err {
+			t.Fatalf("change %d %+v expected error %v, got %v", i, c.change, c.err, result.err)
+		}
+	}
+	if perms, _ := s.RolePermissions("sensors"); len(perms) != 2 || perms[1].Type != permReadWrite {
+		t.Fatalf("unexpected permissions of sensors %+v", perms)
+	}
+
+	data, err := takeSnapshot(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := newTestKVStore()
+	if err := restored.Restore(bytes.NewReader(data), 0); err != nil {
+		t.Fatal(err)
+	}
+	if kvs, _ := restored.Range("", "", 10); len(kvs) != 0 {
+		t.Fatalf("reserved keys returned %+v", kvs)
+	}
+
+	put := "/api.v2.KeyValueService/Put"
+	for _, s := range []*kvstore{s, restored} {
+		if !s.AuthEnabled() {
+			t.Fatal("auth not enabled")
+		}
+		tests := []struct {
+			user, method string
+			keys         []keyAccess
+			allowed      bool
+		}{
+			{rootUser, "/api.v1.RaftService/Remove", nil, true},
+			{"device", put, []keyAccess{singleKey("sensors/1/temp", true)}, true},
+			{"device", put, []keyAccess{singleKey("devices/1", true)}, false},
+			{"device", "/api.v1.RaftService/Remove", nil, false},
+			{"device", "/api.v2.KeyValueService/Range", []keyAccess{{Start: "sensors/", End: "sensors0"}}, true},
+			{"device", "/api.v2.KeyValueService/Range", []keyAccess{{Start: "sensors/"}}, false},
+			{"device", "/api.v2.KeyValueService/Range", []keyAccess{{Start: "sensor", End: "sensors0"}}, false},
+			{"unknown", put, nil, false},
+		}
+		for _, tt := range tests {
+			if err := s.Authorize(tt.user, tt.method, tt.keys); (err == nil) != tt.allowed {
+				t.Fatalf("%s calling %s on %+v expected allowed %v, got %v", tt.user, tt.method, tt.keys, tt.allowed, err)
+			}
+		}
+	}
+
+	// users lose deleted roles
+	testApply(s, 100, command{Op: opAuth, Auth: &authChange{Action: authRoleDelete, Role: "sensors"}})
+	if roles, _ := s.UserRoles("device"); len(roles) != 0 {
+		t.Fatalf("deleted role still granted %+v", roles)
+	}
+	if err := s.Authorize("device", put, []keyAccess{singleKey("sensors/1/temp", true)}); err != errPermissionDenied {
+		t.Fatalf("expected permission denied, got %v", err)
+	}
+}
+
+func Test_KVStore_commandCodec(t *testing.T) {
+	commands := []command{
+		{ID: 1, Op: opPut, Key: "sensors/1/temp", Val: string([]byte{0x00, 0xff}), Lease: 7},
+		{ID: 2, Op: opDelete, Key: "sensors/1/temp"},
+		{ID: 3, Op: opTxn, Txn: &txn{
+			Compares: []compare{{Key: "counter", Target: compareModIndex, Result: compareLess, ModIndex: 4}},
+			Success:  []txnOp{{Op: opPut, Key: "counter", Val: "1"}},
+			Failure:  []txnOp{{Op: opDelete, Key: "counter"}},
+		}},
+		{ID: 4, Op: opLeaseGrant, Lease: 7, TTL: 10},
+		{ID: 5, Op: opLeaseKeepAlive, Lease: 7},
+		{ID: 6, Op: opLeaseRevoke, Lease: 7},
+		{ID: 7, Op: opAuth, Auth: &authChange{Action: authUserAdd, User: "device", PasswordHash: []byte("hash")}},
+		{ID: 8, Op: opAuth, Auth: &authChange{Action: authRoleGrantPermission, Role: "sensors", Perm: permission{KeyPrefix: "sensors/", Type: permReadWrite}}},
+	}
+	for _, want := range commands {
+		data, err := encodeCommand(want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := decodeCommand(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("command expected %+v, got %+v", want, got)
+		}
+	}
+
+	// entries written before the protobuf envelope are gob encoded
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(commands[0]); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := decodeCommand(buf.Bytes()); err != nil || !reflect.DeepEqual(got, commands[0]) {
+		t.Fatalf("legacy command expected %+v, got %+v (%v)", commands[0], got, err)
+	}
+
+	newer, err := proto.Marshal(&storeV1.Command{Version: commandVersion + 1, Op: storeV1.Command_PUT, Id: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := decodeCommand(append([]byte{commandMagic}, newer...)); !errors.Is(err, errUnsupportedCommand) || got.ID != 8 {
+		t.Fatalf("expected unsupported command 8, got %+v (%v)", got, err)
+	}
+}
+
+func Test_KVStore_stopsAtUnsupportedCommands(t *testing.T) {
+	s := newTestKVStore()
+
+	newer, err := proto.Marshal(&storeV1.Command{Version: commandVersion + 1, Op: storeV1.Command_PUT, Id: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	put, err := encodeCommand(command{Op: opPut, Key: "foo", Val: "bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var unknownOp bytes.Buffer
+	if err := gob.NewEncoder(&unknownOp).Encode(command{Op: opAuth + 1, Key: "foo"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range map[string][]byte{
+		"newer version": append([]byte{commandMagic}, newer...),
+		"garbage":       []byte("\x00garbage"),
+		"unknown op":    unknownOp.Bytes(),
+	} {
+		// entries around the one that can't be applied are left out too
+		if _, err := s.Apply([]Entry{{Index: 1, Data: put}, {Index: 2, Data: data}, {Index: 3, Data: put}}); err == nil {
+			t.Fatalf("%s: apply expected to fail", name)
+		}
+		if _, ok := s.Lookup("foo"); ok || s.backend.appliedIndex() != 0 {
+			t.Fatalf("%s: store expected to be unchanged", name)
+		}
+	}
+}
+
+func Test_KVStore_binarySnapshot(t *testing.T) {
+	s := newTestKVStore()
+	testApply(s, 1, command{Op: opLeaseGrant, Lease: 7, TTL: 10})
+	testApply(s, 2, command{Op: opPut, Key: "devices/1/presence", Val: "online", Lease: 7})
+	testApply(s, 3, command{Op: opPut, Key: "sensors/1/temp", Val: string([]byte{0x00, 0xff})})
+
+	data, err := takeSnapshot(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := newTestKVStore()
+	var header snapshotHeader
+	err = restored.backend.reset(3, func(tx backendTx) error {
+		header, err = readSnapshot(bytes.NewReader(data), tx)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// both pairs and the start of watch history
+	wantHeader := snapshotHeader{Version: snapshotVersion, AppliedIndex: 3, Leases: 1, Entries: 3}
+	if header != wantHeader {
+		t.Fatalf("header expected %+v, got %+v", wantHeader, header)
+	}
+	if !reflect.DeepEqual(metas(restored), metas(s)) || !reflect.DeepEqual(contents(restored), contents(s)) {
+		t.Fatalf("store expected %+v %+v, got %+v %+v", contents(s), metas(s), contents(restored), metas(restored))
+	}
+	if keys := leaseKeys(restored, 7); !reflect.DeepEqual(keys, []string{"devices/1/presence"}) {
+		t.Fatalf("key not attached to restored lease: %+v", keys)
+	}
+	if kvs, _ := restored.Range("", "", 10); len(kvs) != 2 {
+		t.Fatalf("key index not restored: %+v", kvs)
+	}
+
+	corrupted := append([]byte(nil), data...)
+	corrupted[len(corrupted)-6] ^= 0xff
+	if err := newTestKVStore().Restore(bytes.NewReader(corrupted), 0); err == nil {
+		t.Fatalf("corrupted snapshot recovered")
+	}
+	if err := newTestKVStore().Restore(bytes.NewReader(data[:len(data)-1]), 0); err == nil {
+		t.Fatalf("truncated snapshot recovered")
+	}
+	if err := restored.Restore(bytes.NewReader(corrupted), 0); err == nil || len(contents(restored)) != 2 {
+		t.Fatalf("store changed by a corrupted snapshot: %+v (%v)", contents(restored), err)
+	}
+}
+
+// Test_KVStore_snapshotView tests updates don't wait for a snapshot being written and
+// don't change it.
+func Test_KVStore_snapshotView(t *testing.T) {
+	s := newTestKVStore()
+	testApply(s, 1, command{Op: opPut, Key: "foo", Val: "bar"})
+	testApply(s, 2, command{Op: opPut, Key: "qux", Val: "quux"})
+
+	write, err := s.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(write(pw))
+	}()
+	// the snapshot is being written once its first byte can be read
+	first := make([]byte, 1)
+	if _, err := io.ReadFull(pr, first); err != nil {
+		t.Fatal(err)
+	}
+
+	updated := make(chan struct{})
+	go func() {
+		testApply(s, 3, command{Op: opPut, Key: "foo", Val: "baz"})
+		testApply(s, 4, command{Op: opDelete, Key: "qux"})
+		close(updated)
+	}()
+	select {
+	case <-updated:
+	case <-time.After(time.Second):
+		t.Fatal("update expected not to wait for the snapshot")
+	}
+
+	rest, err := io.ReadAll(pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := newTestKVStore()
+	if err := restored.Restore(bytes.NewReader(append(first, rest...)), 2); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"foo": "bar", "qux": "quux"}; !reflect.DeepEqual(contents(restored), want) {
+		t.Fatalf("snapshot expected %+v, got %+v", want, contents(restored))
+	}
+}
+
+func takeSnapshot(s *kvstore) ([]byte, error) {
+	write, err := s.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	err = write(&buf)
+	return buf.Bytes(), err
+}
+
+// testApply applies a single command at the index like Apply does.
+func testApply(s *kvstore, index uint64, cmd command) ([]event, applyResult) {
+	var events []event
+	var result applyResult
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.backend.update(index, func(tx backendTx) error {
+		events, result = s.apply(tx, index, cmd)
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	return events, result
+}
+
+// contents returns all pairs of the store outside the reserved key space
+func contents(s *kvstore) map[string]string {
+	kvs := make(map[string]string)
+	s.backend.view(func(tx backendTx) error {
+		tx.ascend("", "", func(key, val string, meta keyMeta) bool {
+			if reservedKey(key) {
+				return true
+			}
+			kvs[key] = val
+			return true
+		})
+		return nil
+	})
+	return kvs
+}
+
+// metas returns metadata of all keys of the store outside the reserved key space
+func metas(s *kvstore) map[string]keyMeta {
+	metas := make(map[string]keyMeta)
+	s.backend.view(func(tx backendTx) error {
+		tx.ascend("", "", func(key, val string, meta keyMeta) bool {
+			if reservedKey(key) {
+				return true
+			}
+			metas[key] = meta
+			return true
+		})
+		return nil
+	})
+	return metas
+}
+
+// leaseKeys returns the keys attached to the lease in ascending order
+func leaseKeys(s *kvstore, id int64) []string {
+	var keys []string
+	s.backend.view(func(tx backendTx) error {
+		keys = tx.leaseKeys(id)
+		return nil
+	})
+	sort.Strings(keys)
+	return keys
+}
+
+func Test_KVStore_boltBackend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.db")
+	open := func() *kvstore {
+		s, err := newKVStore(1, mustOpenBoltBackend(t, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	var entries []Entry
+	for i, cmd := range []command{
+		{Op: opLeaseGrant, Lease: 7, TTL: 10},
+		{Op: opPut, Key: "devices/1/presence", Val: "online", Lease: 7},
+		{Op: opPut, Key: "sensors/1/temp", Val: "21.5"},
+		{Op: opPut, Key: "sensors/2/temp", Val: "19"},
+		{Op: opDelete, Key: "sensors/2/temp"},
+	} {
+		data, err := encodeCommand(cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, Entry{Index: uint64(i + 1), Data: data})
+	}
+
+	s := open()
+	if _, err := s.Apply(entries[:3]); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// updates survive a restart and entries replayed by raft are skipped
+	s = open()
+	defer s.Close()
+	if s.AppliedIndex() != 3 {
+		t.Fatalf("applied index expected 3, got %d", s.AppliedIndex())
+	}
+	_, backlog, err := s.Watch("sensors/", true, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []event{{opPut, "sensors/1/temp", "21.5", 3}}; !reflect.DeepEqual(backlog, want) {
+		t.Fatalf("history expected to survive a restart %+v, got %+v", want, backlog)
+	}
+	if results, err := s.Apply(entries); err != nil || len(results) != 2 {
+		t.Fatalf("replayed entries applied again: %+v", results)
+	}
+	want := map[string]string{"devices/1/presence": "online", "sensors/1/temp": "21.5"}
+	if !reflect.DeepEqual(contents(s), want) {
+		t.Fatalf("store expected %+v, got %+v", want, contents(s))
+	}
+	if kv, _ := s.Get("sensors/1/temp"); kv.ModIndex != 3 {
+		t.Fatalf("unexpected state of sensors/1/temp %+v", kv)
+	}
+	if keys := leaseKeys(s, 7); !reflect.DeepEqual(keys, []string{"devices/1/presence"}) {
+		t.Fatalf("key not attached to loaded lease: %+v", keys)
+	}
+	if _, ok := s.leases[7]; !ok {
+		t.Fatalf("lease not loaded: %+v", s.leases)
+	}
+
+	data, err := takeSnapshot(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte(dbSnapshotMagic)) {
+		t.Fatalf("database file not snapshotted: %q", data[:4])
+	}
+
+	// database snapshots are restored by both backends
+	restored, err := newKVStore(2, mustOpenBoltBackend(t, filepath.Join(t.TempDir(), "restored.db")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	restoreDir := t.TempDir()
+	inMemory, err := newKVStore(3, newMemoryBackend(restoreDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []*kvstore{restored, inMemory} {
+		if err := r.Restore(bytes.NewReader(data), 6); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(contents(r), want) || !reflect.DeepEqual(metas(r), metas(s)) {
+			t.Fatalf("store expected %+v, got %+v", want, contents(r))
+		}
+		if r.AppliedIndex() != 6 {
+			t.Fatalf("applied index expected 6, got %d", r.AppliedIndex())
+		}
+		_, backlog, err := r.Watch("sensors/", true, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(backlog) != 3 || backlog[2] != (event{opDelete, "sensors/2/temp", "", 5}) {
+			t.Fatalf("history expected to be restored, got %+v", backlog)
+		}
+		events, result := testApply(r, 7, command{Op: opLeaseRevoke, Lease: 7})
+		if result.err != nil || len(events) != 1 {
+			t.Fatalf("unexpected revoke result %+v, events: %+v", result, events)
+		}
+	}
+
+	if files, _ := os.ReadDir(restoreDir); len(files) != 0 {
+		t.Fatalf("files of restoring expected to be removed, got %v", files)
+	}
+
+	corrupted := append([]byte(nil), data...)
+	corrupted[len(corrupted)-6] ^= 0xff
+	if err := restored.Restore(bytes.NewReader(corrupted), 8); err == nil {
+		t.Fatalf("corrupted snapshot recovered")
+	}
+	if kvs := contents(restored); len(kvs) != 1 || restored.AppliedIndex() != 7 {
+		t.Fatalf("store changed by a corrupted snapshot: %+v", kvs)
+	}
+
+	// snapshots of in-memory stores are restored by the disk-backed one
+	mem := newTestKVStore()
+	testApply(mem, 1, command{Op: opPut, Key: "foo", Val: "bar"})
+	if data, err = takeSnapshot(mem); err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.Restore(bytes.NewReader(data), 1); err != nil {
+		t.Fatal(err)
+	}
+	if kvs := contents(restored); !reflect.DeepEqual(kvs, map[string]string{"foo": "bar"}) {
+		t.Fatalf("store expected foo only, got %+v", kvs)
+	}
+}
+
+func Test_KVStore_keyTooLarge(t *testing.T) {
+	s, err := newKVStore(1, mustOpenBoltBackend(t, filepath.Join(t.TempDir(), "store.db")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	leasedKey := strings.Repeat("k", maxLeasedKeySize)
+	var ents []Entry
+	for i, cmd := range []command{
+		{Op: opLeaseGrant, Lease: 7, TTL: 10},
+		{Op: opPut, Key: leasedKey + "k", Val: "1", Lease: 7},
+		{Op: opTxn, Txn: &txn{Success: []txnOp{{Op: opPut, Key: strings.Repeat("k", maxKeySize+1)}}}},
+		{Op: opPut, Key: leasedKey, Val: "1", Lease: 7},
+	} {
+		data, err := encodeCommand(cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ents = append(ents, Entry{Index: uint64(i + 1), Data: data})
+	}
+
+	// oversized keys fail their entries only, the batch is committed
+	results, err := s.Apply(ents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []error{nil, errKeyTooLarge, errKeyTooLarge, nil} {
+		if !errors.Is(results[i].Err, want) {
+			t.Fatalf("entry %d expected to fail with %v, got %v", i+1, want, results[i].Err)
+		}
+	}
+	if v, _ := s.Lookup(leasedKey); v != "1" {
+		t.Fatalf("leased key of maximum size not put, got %q", v)
+	}
+}
+
+func mustOpenBoltBackend(t *testing.T, path string) *boltBackend {
+	b, err := openBoltBackend(path)
+	if err != nil {
+		t.Fatal(err)
 	}
+	return b
 }
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"time"
+)
+
+// leaseCheckInterval is how often the leader looks for expired leases
+const leaseCheckInterval = 500 * time.Millisecond
+
+var (
+	errLeaseNotFound = errors.New("lease not found")
+	errLeaseExists   = errors.New("lease already exists")
+)
+
+// lease keeps attached keys alive until it expires or gets revoked, keys attached
+// to it are tracked by the backend
+type lease struct {
+	TTL    int64     // time to live in seconds
+	expiry time.Time // local deadline, only acted upon by the leader
+}
+
+// leaseState is the snapshot representation of a lease
+type leaseState struct {
+	TTL int64
+}
+
+func newLease(ttl int64) *lease {
+	return &lease{TTL: ttl, expiry: time.Now().Add(time.Duration(ttl) * time.Second)}
+}
+
+func (l *lease) refresh() {
+	l.expiry = time.Now().Add(time.Duration(l.TTL) * time.Second)
+}
+
+// GrantLease replicates a new lease with the TTL in seconds. A zero ID is replaced
+// with a generated one. It returns the lease ID and the raft log index the lease was
+// granted at.
+func (s *kvstore) GrantLease(ctx context.Context, id int64, ttl int64) (int64, uint64, error) {
+	if id == 0 {
+		id = int64(s.reqIDGen.Next() & math.MaxInt64)
+	}
+	result, err := s.propose(ctx, command{Op: opLeaseGrant, Lease: id, TTL: ttl})
+	return id, result.index, err
+}
+
+// KeepAliveLease replicates a refresh of the lease deadline and returns its TTL in seconds.
+func (s *kvstore) KeepAliveLease(ctx context.Context, id int64) (int64, uint64, error) {
+	result, err := s.propose(ctx, command{Op: opLeaseKeepAlive, Lease: id})
+	return result.ttl, result.index, err
+}
+
+// RevokeLease replicates removal of the lease together with all of its keys.
+func (s *kvstore) RevokeLease(ctx context.Context, id int64) (uint64, error) {
+	result, err := s.propose(ctx, command{Op: opLeaseRevoke, Lease: id})
+	return result.index, err
+}
+
+// LeaseKeys returns the keys attached to the lease
+func (s *kvstore) LeaseKeys(id int64) []string {
+	var keys []string
+	s.backend.view(func(tx backendTx) error {
+		keys = tx.leaseKeys(id)
+		return nil
+	})
+	return keys
+}
+
+// applyLease applies a lease command. It has to be called with the store lock held.
+func (s *kvstore) applyLease(tx backendTx, index uint64, cmd command, result *applyResult) []event {
+	l, ok := s.leases[cmd.Lease]
+	switch cmd.Op {
+	case opLeaseGrant:
+		if ok {
+			result.err = errLeaseExists
+			return nil
+		}
+		tx.putLease(cmd.Lease, cmd.TTL)
+		s.leases[cmd.Lease] = newLease(cmd.TTL)
+		result.ttl = cmd.TTL
+		return nil
+	case opLeaseKeepAlive:
+		if !ok {
+			result.err = errLeaseNotFound
+			return nil
+		}
+		l.refresh()
+		result.ttl = l.TTL
+		return nil
+	case opLeaseRevoke:
+		if !ok {
+			result.err = errLeaseNotFound
+			return nil
+		}
+		keys := tx.leaseKeys(cmd.Lease)
+		events := make([]event, 0, len(keys))
+		for _, key := range keys {
+			if ev, ok := s.delete(tx, index, key); ok {
+				events = append(events, ev)
+			}
+		}
+		tx.deleteLease(cmd.Lease)
+		delete(s.leases, cmd.Lease)
+		return events
+	default:
+		return nil
+	}
+}
+
+// loadLeases starts deadlines of the leases kept by the backend. Deadlines aren't
+// replicated so loaded leases get a full TTL.
+func (s *kvstore) loadLeases() error {
+	leases := make(map[int64]*lease)
+	err := s.backend.view(func(tx backendTx) error {
+		tx.forEachLease(func(id, ttl int64) {
+			leases[id] = newLease(ttl)
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.leases = leases
+	return nil
+}
+
+// expireLeases revokes expired leases until donec gets closed. Leases are only
+// revoked while isLeader reports this node leads the cluster, so every replica
+// deletes the keys at the same log index.
+func (s *kvstore) expireLeases(isLeader func() bool, donec <-chan struct{}) {
+	ticker := time.NewTicker(leaseCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-donec:
+			return
+		}
+		if !isLeader() {
+			continue
+		}
+
+		now := time.Now()
+		var expired []int64
+		s.mu.RLock()
+		for id, l := range s.leases {
+			if now.After(l.expiry) {
+				expired = append(expired, id)
+			}
+		}
+		s.mu.RUnlock()
+
+		for _, id := range expired {
+			ctx, cancel := context.WithTimeout(context.Background(), leaseCheckInterval)
+			if _, err := s.RevokeLease(ctx, id); err != nil && !errors.Is(err, errLeaseNotFound) {
+				log.Printf("raftexample: failed to revoke expired lease %d (%v)", id, err)
+			}
+			cancel()
+		}
+	}
+}
@@ -1,42 +1,92 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"os"
 	"strings"
 
 	"go.etcd.io/etcd/raft/v3/raftpb"
+	"go.etcd.io/etcd/server/v3/storage/wal"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 )
 
 func main() {
-	cluster := flag.String("cluster", "http://127.0.0.1:9021", "comma separated cluster peers")
-	id := flag.Int("id", 1, "node ID")
-	kvPort := flag.Int("port", 9121, "key-value server port")
-	join := flag.Bool("join", false, "join an existing cluster")
-	storePath := flag.String("storePath", "./", "path where raft state will be kept")
-	flag.Parse()
-
 	log, err := zap.NewDevelopment()
 	if err != nil {
 		panic(err)
 	}
 
+	cfg, err := loadConfig(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		log.Fatal("Couldn't load configuration", zap.Error(err))
+	}
+	// the segment size is kept by the wal package for all WALs of the process
+	wal.SegmentSizeBytes = cfg.WALSegmentSize
+
+	// closed by gracefulShutdown, which stops the raft node
 	proposeC := make(chan string)
-	defer close(proposeC)
-	confChangeC := make(chan raftpb.ConfChange)
+	confChangeC := make(chan raftpb.ConfChangeI)
 	defer close(confChangeC)
 
-	// raft provides a commit stream for the proposals from the http api
-	var kvs *kvstore
-	getSnapshot := func() ([]byte, error) { return kvs.getSnapshot() }
-	commitC, errorC, snapshotterReady := newRaftNode(*id, strings.Split(*cluster, ","), *join, getSnapshot, proposeC, confChangeC, *storePath)
+	// raft replicates the key-value store proposed to by the GRPC api
+	b, err := openBackend(cfg.Backend, fmt.Sprintf("%s/raftexample-%d.db", cfg.StorePath, cfg.ID))
+	if err != nil {
+		log.Fatal("Couldn't open key-value store backend", zap.Error(err))
+	}
+	kvs, err := newKVStore(cfg.ID, b)
+	if err != nil {
+		log.Fatal("Couldn't load key-value store", zap.Error(err))
+	}
+	kvs.catchUpEntries = cfg.Raft.SnapshotCatchUpEntries
+	defer kvs.Close()
+	node, errorC, err := newRaftNode(cfg.ID, strings.Split(cfg.Cluster, ","), cfg.Join, cfg.Raft, kvs, proposeC, confChangeC, cfg.StorePath)
+	if err != nil {
+		log.Fatal("Couldn't start raft node", zap.Error(err))
+	}
+	kvs.proposer = node
+	go kvs.expireLeases(node.isLeader, node.done())
+	go func() {
+		if err, ok := <-errorC; ok {
+			log.Fatal("Raft node failed", zap.Error(err))
+		}
+	}()
+
+	if cfg.GRPCCluster != "" {
+		for i, url := range strings.Split(cfg.GRPCCluster, ",") {
+			node.setClientURL(uint64(i+1), url)
+		}
+	}
+	dialCreds, err := grpcDialCredentials(cfg.grpcTLSInfo())
+	if err != nil {
+		log.Fatal("Couldn't load GRPC client certificates", zap.Error(err))
+	}
+	fwd := newForwarder(log, node, dialCreds)
+	defer fwd.Close()
+	auth := newAuthorizer(log, kvs)
 
-	kvs = newKVStore(<-snapshotterReady, proposeC, commitC, errorC)
+	if cfg.MetricsAddr != "" {
+		metrics, err := serveMetrics(cfg.MetricsAddr)
+		if err != nil {
+			log.Fatal("Couldn't serve metrics", zap.Error(err))
+		}
+		defer metrics.Close()
+	}
+
+	server, err := NewGRPCServer(&cfg, log,
+		grpc.ChainUnaryInterceptor(metricsUnaryInterceptor, auth.UnaryInterceptor, fwd.UnaryInterceptor),
+		grpc.ChainStreamInterceptor(metricsStreamInterceptor, auth.StreamInterceptor),
+	)
+	if err != nil {
+		log.Fatal("Couldn't create GRPC server", zap.Error(err))
+	}
+	newController(server, log, kvs, node)
 
-	server := grpc.NewServer()
-	newController(server, log, kvs, confChangeC)
+	startGRPC(server, cfg, log)
 
-	startGRPC(server, Config{Address: fmt.Sprintf("0.0.0.0:%d", *kvPort), Network: "tcp"}, log)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	gracefulShutdown(ctx, log, server, node, kvs, proposeC)
 }
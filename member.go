@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+)
+
+// memberContext is replicated in the context of conf changes adding members
+type memberContext struct {
+	PeerURL   string `json:"peerURL"`
+	ClientURL string `json:"clientURL,omitempty"`
+}
+
+func (m memberContext) marshal() ([]byte, error) {
+	return json.Marshal(m)
+}
+
+// parseMemberContext decodes context of a conf change. Context holding just the
+// peer URL is accepted too, so conf changes proposed that way keep working.
+func parseMemberContext(data []byte) memberContext {
+	var m memberContext
+	if err := json.Unmarshal(data, &m); err != nil || m.PeerURL == "" {
+		return memberContext{PeerURL: string(data)}
+	}
+	return m
+}
+
+// validate checks the peer URL is a raft http URL and the client URL is a gRPC address
+func (m memberContext) validate() error {
+	u, err := url.Parse(m.PeerURL)
+	if err != nil {
+		return fmt.Errorf("invalid peer URL: %s", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("invalid peer URL %q: expected http(s)://host:port", m.PeerURL)
+	}
+	if m.ClientURL != "" {
+		if _, _, err := net.SplitHostPort(m.ClientURL); err != nil {
+			return fmt.Errorf("invalid client URL %q: expected host:port", m.ClientURL)
+		}
+	}
+	return nil
+}
+
+// member of the raft configuration
+type member struct {
+	memberContext
+	ID      uint64 `json:"id"`
+	Learner bool   `json:"learner,omitempty"`
+}
+
+// nodeStatus is a point in time view of a raft node
+type nodeStatus struct {
+	ID            uint64
+	Lead          uint64
+	State         string
+	Term          uint64
+	CommitIndex   uint64
+	AppliedIndex  uint64
+	SnapshotIndex uint64
+	ApplyError    string // set once the state machine failed
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// Metrics are served at /metrics of the raft HTTP server together with the ones
+// etcd packages register, like etcd_disk_wal_fsync_duration_seconds and
+// etcd_network_peer_sent_failures_total.
+var (
+	proposalsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "raftexample",
+		Subsystem: "raft",
+		Name:      "proposals_total",
+		Help:      "The total number of proposals handed over to raft.",
+	})
+	proposalsFailed = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "raftexample",
+		Subsystem: "raft",
+		Name:      "proposals_failed_total",
+		Help:      "The total number of proposals that weren't applied, because raft refused them, they timed out or the node stopped.",
+	})
+	proposalDurationSec = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "raftexample",
+		Subsystem: "raft",
+		Name:      "proposal_duration_seconds",
+		Help:      "The latency distribution of proposals, from proposing until the state machine applied them or they failed, by result: applied or failed.",
+		// 1ms to ~16s
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 15),
+	}, []string{"result"})
+	proposalBatchEntries = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "raftexample",
+		Subsystem: "raft",
+		Name:      "proposal_batch_entries",
+		Help:      "The distribution of the number of proposals handed over to raft in a single batch.",
+		// 1 to 4096
+		Buckets: prometheus.ExponentialBuckets(1, 2, 13),
+	})
+	appliedIndexGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "raftexample",
+		Subsystem: "raft",
+		Name:      "applied_index",
+		Help:      "The index of the last entry handed over to the state machine.",
+	})
+	applyLag = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "raftexample",
+		Subsystem: "raft",
+		Name:      "apply_lag_entries",
+		Help:      "The number of committed entries not handed over to the state machine yet.",
+	})
+	snapshotDurationSec = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "raftexample",
+		Subsystem: "raft",
+		Name:      "snapshot_duration_seconds",
+		Help:      "The latency distribution of taking and saving snapshots, including log compaction.",
+		// 1ms to ~16s
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 15),
+	})
+	walSaveDurationSec = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "raftexample",
+		Subsystem: "raft",
+		Name:      "wal_save_duration_seconds",
+		Help:      "The latency distribution of saving ready entries and state to the WAL, fsync included.",
+		// 1ms to ~4s
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 13),
+	})
+	leaderChanges = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "raftexample",
+		Subsystem: "raft",
+		Name:      "leader_changes_total",
+		Help:      "The number of leader changes seen.",
+	})
+	isLeaderGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "raftexample",
+		Subsystem: "raft",
+		Name:      "is_leader",
+		Help:      "Whether this node is the leader, 1 if it is and 0 otherwise.",
+	})
+
+	rpcRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "raftexample",
+		Subsystem: "grpc",
+		Name:      "requests_total",
+		Help:      "The total number of RPCs handled, unary and streaming, by method and status code.",
+	}, []string{"method", "code"})
+	rpcStreamsOpen = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "raftexample",
+		Subsystem: "grpc",
+		Name:      "streams_open",
+		Help:      "The number of streaming RPCs being served, like watches, by method.",
+	}, []string{"method"})
+	rpcDurationSec = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "raftexample",
+		Subsystem: "grpc",
+		Name:      "request_duration_seconds",
+		Help:      "The latency distribution of unary RPCs, by method.",
+		// 1ms to ~16s
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 15),
+	}, []string{"method"})
+)
+
+func init() {
+	prometheus.MustRegister(proposalsTotal)
+	prometheus.MustRegister(proposalsFailed)
+	prometheus.MustRegister(proposalDurationSec)
+	prometheus.MustRegister(proposalBatchEntries)
+	prometheus.MustRegister(appliedIndexGauge)
+	prometheus.MustRegister(applyLag)
+	prometheus.MustRegister(snapshotDurationSec)
+	prometheus.MustRegister(walSaveDurationSec)
+	prometheus.MustRegister(leaderChanges)
+	prometheus.MustRegister(isLeaderGauge)
+	prometheus.MustRegister(rpcRequests)
+	prometheus.MustRegister(rpcDurationSec)
+	prometheus.MustRegister(rpcStreamsOpen)
+}
+
+// metricsUnaryInterceptor counts unary RPCs and measures their latency.
+func metricsUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	rpcDurationSec.WithLabelValues(info.FullMethod).Observe(time.Since(start).Seconds())
+	rpcRequests.WithLabelValues(info.FullMethod, status.Code(err).String()).Inc()
+	return resp, err
+}
+
+// metricsStreamInterceptor counts streaming RPCs and the ones being served.
+func metricsStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	open := rpcStreamsOpen.WithLabelValues(info.FullMethod)
+	open.Inc()
+	defer open.Dec()
+	err := handler(srv, ss)
+	rpcRequests.WithLabelValues(info.FullMethod, status.Code(err).String()).Inc()
+	return err
+}
+
+// serveMetrics serves the metrics over plain HTTP on the address, so they can be
+// scraped without a peer certificate once peer traffic uses TLS.
+func serveMetrics(addr string) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("cannot listen for metrics on %s: %w", addr, err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(ln)
+	return srv, nil
+}
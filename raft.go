@@ -2,16 +2,26 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"sort"
 	"strconv"
+	"sync"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.etcd.io/etcd/client/pkg/v3/fileutil"
 	"go.etcd.io/etcd/client/pkg/v3/types"
+	"go.etcd.io/etcd/pkg/v3/idutil"
+	"go.etcd.io/etcd/pkg/v3/wait"
 	"go.etcd.io/etcd/raft/v3"
 	"go.etcd.io/etcd/raft/v3/raftpb"
 	"go.etcd.io/etcd/server/v3/etcdserver/api/rafthttp"
@@ -23,89 +33,175 @@ import (
 	"go.uber.org/zap"
 )
 
-type commit struct {
-	data       []string
-	applyDoneC chan<- struct{}
-}
-
 // A key-value stream backed by raft
 type raftNode struct {
-	proposeC    <-chan string            // proposed messages (k,v)
-	confChangeC <-chan raftpb.ConfChange // proposed cluster config changes
-	commitC     chan<- *commit           // entries committed to log (k,v)
-	errorC      chan<- error             // errors from raft session
-
-	id          int      // client ID for raft session
-	peers       []string // raft peer URLs
-	join        bool     // node is joining an existing cluster
-	waldir      string   // path to WAL directory
-	snapdir     string   // path to snapshot directory
-	getSnapshot func() ([]byte, error)
-
+	proposeC    <-chan string             // proposed messages (k,v)
+	confChangeC <-chan raftpb.ConfChangeI // proposed cluster config changes
+	errorC      chan<- error              // errors from raft session
+
+	id      int      // client ID for raft session
+	peers   []string // raft peer URLs
+	join    bool     // node is joining an existing cluster
+	waldir  string   // path to WAL directory
+	snapdir string   // path to snapshot directory
+
+	sm           StateMachine     // replicated application state
+	applyC       chan *applyBatch // entries and snapshots handed over to the state machine
+	propc        chan proposal    // proposals handed over to raft in batches
+	proposeWait  wait.Wait        // proposals waiting for their results
+	donec        chan struct{}    // closed once the state machine is no longer applied to
+	applyFailedC chan struct{}    // closed once the state machine failed, entries aren't applied anymore
+
+	// written by the raft loop only, under mu since status reads them
 	confState     raftpb.ConfState
 	snapshotIndex uint64
 	appliedIndex  uint64
 
+	// used by the raft loop only
+	commitIndex uint64 // last commit index raft reported
+	lead        uint64 // last leader raft reported
+
+	mu       sync.RWMutex             // guards fields read outside of the raft loop
+	members  map[uint64]memberContext // URLs of members by node ID
+	draining bool                     // proposals are refused once the node shuts down
+	applyErr error                    // failure of the state machine, set before applyFailedC is closed
+	inflight sync.WaitGroup           // proposals made through Propose, drained on shutdown
+
+	confChangeMu    sync.Mutex        // conf changes are proposed one at a time
+	confChangeIDGen *idutil.Generator // IDs of conf changes proposed through proposeConfChange
+	confChangeWait  wait.Wait         // conf changes waiting to be applied
+
+	readIDGen   *idutil.Generator // request IDs of read index requests
+	readWait    wait.Wait         // read index requests waiting for a read state
+	appliedWait wait.WaitTime     // waits for the state machine to reach an index
+
 	// raft backing for the commit/error channel
 	node        raft.Node
 	raftStorage *raft.MemoryStorage
 	wal         *wal.WAL
 
-	snapshotter      *snap.Snapshotter
-	snapshotterReady chan *snap.Snapshotter // signals when snapshotter is ready
-
-	snapCount uint64
-	transport *rafthttp.Transport
-	stopc     chan struct{} // signals proposal channel closed
-	httpstopc chan struct{} // signals http server to shutdown
-	httpdonec chan struct{} // signals http server shutdown complete
+	snapshotter *snap.Snapshotter
+
+	cfg           RaftConfig
+	clusterID     types.ID // kept in the WAL metadata
+	transport     *rafthttp.Transport
+	stopc         chan struct{}    // signals proposal channel closed
+	stoppedc      chan struct{}    // closed once the raft loop returned and the WAL is closed
+	snapshotC     chan chan error  // requests of a final snapshot, served by the raft loop
+	snapshotDoneC chan snapshotJob // snapshots written off the raft loop
+	snapshotting  bool             // a snapshot is being written, used by the raft loop only
+	holdc         chan struct{}    // has the raft loop hold back Ready while a batch is proposed
+	releasec      chan struct{}    // lets the raft loop take Ready again
+	httpstopc     chan struct{}    // signals http server to shutdown
+	httpdonec     chan struct{}    // signals http server shutdown complete
 
 	logger *zap.Logger
 }
 
-var defaultSnapshotCount uint64 = 10000
+var (
+	// errNoLeader is returned for requests that need a leader while none is known.
+	errNoLeader = errors.New("raftexample: no leader")
+	// errNotLeader is returned for requests only the leader can serve.
+	errNotLeader = errors.New("raftexample: not the leader")
+	// errInvalidTransferee is returned if leadership can't be handed over to the node.
+	errInvalidTransferee = errors.New("raftexample: transferee is not a voting follower")
+	// errNotLearner is returned when promoting a node which isn't a learner.
+	errNotLearner = errors.New("raftexample: node is not a learner")
+	// errLearnerNotReady is returned when promoting a learner lagging behind the leader.
+	errLearnerNotReady = errors.New("raftexample: learner is not in sync with the leader")
+	// errStopped is returned to proposals still waiting when the node stops.
+	errStopped = errors.New("raftexample: node stopped")
+	// errShuttingDown is returned to proposals made once the node started shutting down.
+	errShuttingDown = errors.New("raftexample: node is shutting down")
+	// errInvalidConfChange is returned for conf changes which don't fit the configuration.
+	errInvalidConfChange = errors.New("raftexample: invalid conf change")
+)
+
+// raftError is returned by newRaftNode or sent over the error channel when the
+// node fails, op names what the node couldn't do. The node is stopped once it's
+// been reported.
+type raftError struct {
+	op  string
+	err error
+}
+
+func (e *raftError) Error() string {
+	return fmt.Sprintf("raftexample: cannot %s (%v)", e.op, e.err)
+}
+
+func (e *raftError) Unwrap() error {
+	return e.err
+}
 
-// newRaftNode initiates a raft instance and returns a committed log entry
-// channel and error channel. Proposals for log updates are sent over the
-// provided the proposal channel. All log entries are replayed over the
-// commit channel, followed by a nil message (to indicate the channel is
-// current), then new log entries. To shutdown, close proposeC and read errorC.
+// readyLearnerMatchRatio is the part of the leader's log a learner has to replicate
+// before it can be promoted
+const readyLearnerMatchRatio = 0.9
+
+// leaderCheckInterval is how often leadership transfer checks for the new leader
+const leaderCheckInterval = 50 * time.Millisecond
+
+// newRaftNode initiates a raft instance replicating the state machine and returns
+// it, once the WAL got replayed, together with an error channel. The state machine
+// is restored from the last snapshot and all log entries after it are replayed to it,
+// then new log entries. Updates are replicated through Propose or by sending them
+// over the provided proposal channel. To shutdown, close proposeC and read errorC,
+// call shutdown before to drain proposals and hand leadership over.
+// Failures of a running node are sent over errorC, failures to start it are returned.
 func newRaftNode(
 	id int,
 	peers []string,
 	join bool,
-	getSnapshot func() ([]byte, error),
+	cfg RaftConfig,
+	sm StateMachine,
 	proposeC <-chan string,
-	confChangeC <-chan raftpb.ConfChange,
+	confChangeC <-chan raftpb.ConfChangeI,
 	dirPath string,
-) (<-chan *commit, <-chan error, <-chan *snap.Snapshotter) {
+) (*raftNode, <-chan error, error) {
 
-	commitC := make(chan *commit)
 	errorC := make(chan error)
 
 	rc := &raftNode{
-		proposeC:    proposeC,
-		confChangeC: confChangeC,
-		commitC:     commitC,
-		errorC:      errorC,
-		id:          id,
-		peers:       peers,
-		join:        join,
-		waldir:      fmt.Sprintf("%s/raftexample-%d", dirPath, id),
-		snapdir:     fmt.Sprintf("%s/raftexample-%d-snap", dirPath, id),
-		getSnapshot: getSnapshot,
-		snapCount:   defaultSnapshotCount,
-		stopc:       make(chan struct{}),
-		httpstopc:   make(chan struct{}),
-		httpdonec:   make(chan struct{}),
+		proposeC:      proposeC,
+		confChangeC:   confChangeC,
+		errorC:        errorC,
+		id:            id,
+		peers:         peers,
+		join:          join,
+		waldir:        fmt.Sprintf("%s/raftexample-%d", dirPath, id),
+		snapdir:       fmt.Sprintf("%s/raftexample-%d-snap", dirPath, id),
+		sm:            sm,
+		applyC:        make(chan *applyBatch),
+		propc:         make(chan proposal),
+		proposeWait:   wait.New(),
+		donec:         make(chan struct{}),
+		applyFailedC:  make(chan struct{}),
+		cfg:           cfg,
+		stopc:         make(chan struct{}),
+		stoppedc:      make(chan struct{}),
+		snapshotC:     make(chan chan error),
+		snapshotDoneC: make(chan snapshotJob, 1),
+		holdc:         make(chan struct{}),
+		releasec:      make(chan struct{}),
+		httpstopc:     make(chan struct{}),
+		httpdonec:     make(chan struct{}),
+
+		members:         make(map[uint64]memberContext, len(peers)),
+		confChangeIDGen: idutil.NewGenerator(uint16(id), time.Now()),
+		confChangeWait:  wait.New(),
+		readIDGen:       idutil.NewGenerator(uint16(id), time.Now()),
+		readWait:        wait.New(),
+		appliedWait:     wait.NewTimeList(),
 
 		logger: zap.NewExample(),
-
-		snapshotterReady: make(chan *snap.Snapshotter, 1),
 		// rest of structure populated after WAL replay
 	}
-	go rc.startRaft()
-	return commitC, errorC, rc.snapshotterReady
+	for i, peer := range peers {
+		rc.members[uint64(i+1)] = memberContext{PeerURL: peer}
+	}
+	if err := rc.startRaft(); err != nil {
+		return nil, nil, err
+	}
+	return rc, errorC, nil
 }
 
 func (rc *raftNode) saveSnap(snap raftpb.Snapshot) error {
@@ -123,21 +219,28 @@ func (rc *raftNode) saveSnap(snap raftpb.Snapshot) error {
 	if err := rc.wal.SaveSnapshot(walSnap); err != nil {
 		return err
 	}
-	return rc.wal.ReleaseLockTo(snap.Metadata.Index)
+	if err := rc.wal.ReleaseLockTo(snap.Metadata.Index); err != nil {
+		return err
+	}
+	// the WAL refers to the new snapshot, files of older ones aren't loaded anymore
+	return rc.snapshotter.ReleaseSnapDBs(snap)
 }
 
-func (rc *raftNode) entriesToApply(ents []raftpb.Entry) (nents []raftpb.Entry) {
+func (rc *raftNode) entriesToApply(ents []raftpb.Entry) (nents []raftpb.Entry, err error) {
 	if len(ents) == 0 {
-		return ents
+		return ents, nil
 	}
 	firstIdx := ents[0].Index
 	if firstIdx > rc.appliedIndex+1 {
-		log.Fatalf("first index of committed entry[%d] should <= progress.appliedIndex[%d]+1", firstIdx, rc.appliedIndex)
+		return nil, &raftError{
+			op:  "apply committed entries",
+			err: fmt.Errorf("first index of committed entry[%d] should <= progress.appliedIndex[%d]+1", firstIdx, rc.appliedIndex),
+		}
 	}
 	if rc.appliedIndex-firstIdx+1 < uint64(len(ents)) {
 		nents = ents[rc.appliedIndex-firstIdx+1:]
 	}
-	return nents
+	return nents, nil
 }
 
 // publishEntries writes committed log entries to commit channel and returns
@@ -147,7 +250,7 @@ func (rc *raftNode) publishEntries(ents []raftpb.Entry) (<-chan struct{}, bool)
 		return nil, true
 	}
 
-	data := make([]string, 0, len(ents))
+	entries := make([]Entry, 0, len(ents))
 	for i := range ents {
 		switch ents[i].Type {
 		case raftpb.EntryNormal:
@@ -155,69 +258,177 @@ func (rc *raftNode) publishEntries(ents []raftpb.Entry) (<-chan struct{}, bool)
 				// ignore empty messages
 				break
 			}
-			s := string(ents[i].Data)
-			data = append(data, s)
+			entries = append(entries, Entry{Index: ents[i].Index, Data: ents[i].Data})
 		case raftpb.EntryConfChange:
 			var cc raftpb.ConfChange
 			cc.Unmarshal(ents[i].Data)
-			rc.confState = *rc.node.ApplyConfChange(cc)
-			switch cc.Type {
-			case raftpb.ConfChangeAddNode:
-				if len(cc.Context) > 0 {
-					rc.transport.AddPeer(types.ID(cc.NodeID), []string{string(cc.Context)})
-				}
-			case raftpb.ConfChangeRemoveNode:
-				if cc.NodeID == uint64(rc.id) {
-					log.Println("I've been removed from the cluster! Shutting down.")
-					return nil, false
-				}
-				rc.transport.RemovePeer(types.ID(cc.NodeID))
+			if !rc.publishConfChange(cc, cc.ID) {
+				return nil, false
+			}
+		case raftpb.EntryConfChangeV2:
+			var cc raftpb.ConfChangeV2
+			cc.Unmarshal(ents[i].Data)
+			if !rc.publishConfChange(cc, 0) {
+				return nil, false
 			}
 		}
 	}
 
-	var applyDoneC chan struct{}
-
-	if len(data) > 0 {
-		applyDoneC = make(chan struct{}, 1)
-		select {
-		case rc.commitC <- &commit{data, applyDoneC}:
-		case <-rc.stopc:
-			return nil, false
-		}
+	// batches of conf changes only are handed over too, so readers waiting for
+	// their index get released in order
+	applyDoneC := make(chan struct{})
+	select {
+	case rc.applyC <- &applyBatch{entries: entries, index: ents[len(ents)-1].Index, applyDoneC: applyDoneC}:
+	case <-rc.stopc:
+		return nil, false
 	}
 
 	// after commit, update appliedIndex
+	rc.mu.Lock()
 	rc.appliedIndex = ents[len(ents)-1].Index
+	rc.mu.Unlock()
+	rc.reportApplied()
 
 	return applyDoneC, true
 }
 
-func (rc *raftNode) loadSnapshot() *raftpb.Snapshot {
+// publishConfChange applies the committed conf change unless it doesn't fit the
+// current configuration, and resolves the proposal waiting for it under the ID. All
+// members refuse the same conf changes as they check them at the same log index. It
+// returns false if this node has been removed from the cluster.
+func (rc *raftNode) publishConfChange(cc raftpb.ConfChangeI, id uint64) bool {
+	err := rc.checkConfChange(cc)
+	if err != nil {
+		log.Printf("raftexample: refusing conf change %v (%v)", cc, err)
+		rc.confChangeWait.Trigger(id, err)
+		return true
+	}
+	ok := rc.applyConfChange(cc)
+	rc.confChangeWait.Trigger(id, nil)
+	return ok
+}
+
+// checkConfChange checks the changes add members not in the configuration yet, promote
+// learners and remove members
+func (rc *raftNode) checkConfChange(cc raftpb.ConfChangeI) error {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+	voters := make(map[uint64]bool, len(rc.confState.Voters)+len(rc.confState.Learners))
+	for _, id := range rc.confState.Voters {
+		voters[id] = true
+	}
+	for _, id := range rc.confState.Learners {
+		voters[id] = false
+	}
+	for _, change := range cc.AsV2().Changes {
+		voter, member := voters[change.NodeID]
+		switch {
+		case change.NodeID == raft.None:
+			return fmt.Errorf("%w: node ID must not be zero", errInvalidConfChange)
+		case change.Type == raftpb.ConfChangeAddNode && voter:
+			return fmt.Errorf("%w: node %d is a voter already", errInvalidConfChange, change.NodeID)
+		case change.Type == raftpb.ConfChangeAddLearnerNode && member:
+			return fmt.Errorf("%w: node %d is a member already", errInvalidConfChange, change.NodeID)
+		case change.Type == raftpb.ConfChangeRemoveNode && !member:
+			return fmt.Errorf("%w: node %d is not a member", errInvalidConfChange, change.NodeID)
+		}
+	}
+	return nil
+}
+
+// proposeConfChange replicates the conf change and waits until it's applied. Conf
+// changes of a node are proposed one at a time, raft refuses the ones proposed while
+// another one isn't applied yet and they're never applied then, the context ends the
+// wait for them.
+func (rc *raftNode) proposeConfChange(ctx context.Context, cc raftpb.ConfChange) error {
+	rc.confChangeMu.Lock()
+	defer rc.confChangeMu.Unlock()
+
+	cc.ID = rc.confChangeIDGen.Next()
+	ch := rc.confChangeWait.Register(cc.ID)
+	if err := rc.node.ProposeConfChange(ctx, cc); err != nil {
+		rc.confChangeWait.Trigger(cc.ID, nil)
+		return err
+	}
+	select {
+	case x := <-ch:
+		if err, ok := x.(error); ok {
+			return err
+		}
+		return nil
+	case <-ctx.Done():
+		rc.confChangeWait.Trigger(cc.ID, nil)
+		return ctx.Err()
+	case <-rc.donec:
+		return errStopped
+	}
+}
+
+// applyConfChange applies the conf change and updates the transport with its member
+// changes. It returns false if this node has been removed from the cluster.
+func (rc *raftNode) applyConfChange(cc raftpb.ConfChangeI) bool {
+	confState := rc.node.ApplyConfChange(cc)
+	rc.mu.Lock()
+	rc.confState = *confState
+	rc.mu.Unlock()
+
+	ccv2 := cc.AsV2()
+	for _, change := range ccv2.Changes {
+		switch change.Type {
+		case raftpb.ConfChangeAddNode, raftpb.ConfChangeAddLearnerNode:
+			// promotions of learners carry no context as the member is known already
+			if len(ccv2.Context) > 0 {
+				m := parseMemberContext(ccv2.Context)
+				if change.NodeID != uint64(rc.id) {
+					rc.transport.AddPeer(types.ID(change.NodeID), []string{m.PeerURL})
+				}
+				rc.mu.Lock()
+				rc.members[change.NodeID] = m
+				rc.mu.Unlock()
+			}
+		case raftpb.ConfChangeRemoveNode:
+			if change.NodeID == uint64(rc.id) {
+				log.Println("I've been removed from the cluster! Shutting down.")
+				return false
+			}
+			rc.transport.RemovePeer(types.ID(change.NodeID))
+			rc.mu.Lock()
+			delete(rc.members, change.NodeID)
+			rc.mu.Unlock()
+		}
+	}
+	return true
+}
+
+func (rc *raftNode) loadSnapshot() (*raftpb.Snapshot, error) {
 	if wal.Exist(rc.waldir) {
 		walSnaps, err := wal.ValidSnapshotEntries(rc.logger, rc.waldir)
 		if err != nil {
-			log.Fatalf("raftexample: error listing snapshots (%v)", err)
+			return nil, &raftError{op: "list snapshots", err: err}
 		}
 		snapshot, err := rc.snapshotter.LoadNewestAvailable(walSnaps)
 		if err != nil && err != snap.ErrNoSnapshot {
-			log.Fatalf("raftexample: error loading snapshot (%v)", err)
+			return nil, &raftError{op: "load snapshot", err: err}
 		}
-		return snapshot
+		return snapshot, nil
 	}
-	return &raftpb.Snapshot{}
+	return &raftpb.Snapshot{}, nil
 }
 
 // openWAL returns a WAL ready for reading.
-func (rc *raftNode) openWAL(snapshot *raftpb.Snapshot) *wal.WAL {
+func (rc *raftNode) openWAL(snapshot *raftpb.Snapshot) (*wal.WAL, error) {
 	if !wal.Exist(rc.waldir) {
 		if err := os.Mkdir(rc.waldir, 0750); err != nil {
-			log.Fatalf("raftexample: cannot create dir for wal (%v)", err)
+			return nil, &raftError{op: "create dir for wal", err: err}
 		}
 
-		w, err := wal.Create(zap.NewExample(), rc.waldir, nil)
+		metadata, err := json.Marshal(walMetadata{NodeID: uint64(rc.id), ClusterID: rc.clusterID})
+		if err != nil {
+			return nil, &raftError{op: "encode wal metadata", err: err}
+		}
+		w, err := wal.Create(zap.NewExample(), rc.waldir, metadata)
 		if err != nil {
-			log.Fatalf("raftexample: create wal error (%v)", err)
+			return nil, &raftError{op: "create wal", err: err}
 		}
 		w.Close()
 	}
@@ -229,54 +440,108 @@ func (rc *raftNode) openWAL(snapshot *raftpb.Snapshot) *wal.WAL {
 	log.Printf("loading WAL at term %d and index %d", walsnap.Term, walsnap.Index)
 	w, err := wal.Open(zap.NewExample(), rc.waldir, walsnap)
 	if err != nil {
-		log.Fatalf("raftexample: error loading wal (%v)", err)
+		return nil, &raftError{op: "load wal", err: err}
 	}
 
-	return w
+	return w, nil
 }
 
 // replayWAL replays WAL entries into the raft instance.
-func (rc *raftNode) replayWAL() *wal.WAL {
+func (rc *raftNode) replayWAL() (*wal.WAL, error) {
 	log.Printf("replaying WAL of member %d", rc.id)
-	snapshot := rc.loadSnapshot()
-	w := rc.openWAL(snapshot)
-	_, st, ents, err := w.ReadAll()
+	snapshot, err := rc.loadSnapshot()
 	if err != nil {
-		log.Fatalf("raftexample: failed to read WAL (%v)", err)
+		return nil, err
+	}
+	w, err := rc.openWAL(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	metadata, st, ents, err := w.ReadAll()
+	if err != nil {
+		w.Close()
+		return nil, &raftError{op: "read WAL", err: err}
+	}
+	if err := rc.checkClusterID(metadata); err != nil {
+		w.Close()
+		return nil, err
 	}
 	rc.raftStorage = raft.NewMemoryStorage()
-	if snapshot != nil {
-		rc.raftStorage.ApplySnapshot(*snapshot)
+	if snapshot != nil && !raft.IsEmptySnap(*snapshot) {
+		if err := rc.raftStorage.ApplySnapshot(*snapshot); err != nil {
+			w.Close()
+			return nil, &raftError{op: "apply snapshot to raft storage", err: err}
+		}
+	}
+	if err := rc.raftStorage.SetHardState(st); err != nil {
+		w.Close()
+		return nil, &raftError{op: "set hard state of raft storage", err: err}
 	}
-	rc.raftStorage.SetHardState(st)
 
 	// append to storage so raft starts at the right place in log
-	rc.raftStorage.Append(ents)
+	if err := rc.raftStorage.Append(ents); err != nil {
+		w.Close()
+		return nil, &raftError{op: "append WAL entries to raft storage", err: err}
+	}
 
-	return w
+	return w, nil
 }
 
+// writeError stops the node and reports the error that made it fail.
 func (rc *raftNode) writeError(err error) {
 	rc.stopHTTP()
-	close(rc.commitC)
+	close(rc.applyC)
 	rc.errorC <- err
 	close(rc.errorC)
 	rc.node.Stop()
 }
 
-func (rc *raftNode) startRaft() {
+// startRaft replays the WAL, restores the state machine and starts the raft loop.
+// Whatever got opened is closed again if it fails.
+func (rc *raftNode) startRaft() (err error) {
+	url, err := url.Parse(rc.peers[rc.id-1])
+	if err != nil {
+		return &raftError{op: "parse peer URL", err: err}
+	}
+	var ln net.Listener
+	if ln, err = newStoppableListener(url.Host, rc.httpstopc); err != nil {
+		return &raftError{op: "listen rafthttp", err: err}
+	}
+	defer func() {
+		if err != nil {
+			ln.Close()
+		}
+	}()
+	tlsInfo := rc.cfg.tlsInfo()
+	if tlsInfo != nil {
+		tlsCfg, err := tlsInfo.ServerConfig()
+		if err != nil {
+			return &raftError{op: "load peer certificates", err: err}
+		}
+		ln = tls.NewListener(ln, tlsCfg)
+	}
+
 	if !fileutil.Exist(rc.snapdir) {
 		if err := os.Mkdir(rc.snapdir, 0750); err != nil {
-			log.Fatalf("raftexample: cannot create dir for snapshot (%v)", err)
+			return &raftError{op: "create dir for snapshot", err: err}
 		}
 	}
 	rc.snapshotter = snap.New(zap.NewExample(), rc.snapdir)
 
 	oldwal := wal.Exist(rc.waldir)
-	rc.wal = rc.replayWAL()
-
-	// signal replay has finished
-	rc.snapshotterReady <- rc.snapshotter
+	if !oldwal {
+		if rc.clusterID, err = rc.initialClusterID(); err != nil {
+			return err
+		}
+	}
+	if rc.wal, err = rc.replayWAL(); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			rc.wal.Close()
+		}
+	}()
 
 	rpeers := make([]raft.Peer, len(rc.peers))
 	for i := range rpeers {
@@ -284,12 +549,12 @@ func (rc *raftNode) startRaft() {
 	}
 	c := &raft.Config{
 		ID:                        uint64(rc.id),
-		ElectionTick:              10,
-		HeartbeatTick:             1,
+		ElectionTick:              rc.cfg.ElectionTick,
+		HeartbeatTick:             rc.cfg.HeartbeatTick,
 		Storage:                   rc.raftStorage,
-		MaxSizePerMsg:             1024 * 1024,
-		MaxInflightMsgs:           256,
-		MaxUncommittedEntriesSize: 1 << 30,
+		MaxSizePerMsg:             rc.cfg.MaxSizePerMsg,
+		MaxInflightMsgs:           rc.cfg.MaxInflightMsgs,
+		MaxUncommittedEntriesSize: rc.cfg.MaxUncommittedEntriesSize,
 	}
 
 	if oldwal || rc.join {
@@ -297,32 +562,65 @@ func (rc *raftNode) startRaft() {
 	} else {
 		rc.node = raft.StartNode(c, rpeers)
 	}
+	defer func() {
+		if err != nil {
+			rc.node.Stop()
+		}
+	}()
+
+	snapshot, err := rc.raftStorage.Snapshot()
+	if err != nil {
+		return &raftError{op: "read snapshot", err: err}
+	}
+	members, err := snapshotMembers(snapshot)
+	if err != nil {
+		return &raftError{op: "read members of snapshot", err: err}
+	}
+	if !raft.IsEmptySnap(snapshot) {
+		if applied := rc.sm.AppliedIndex(); applied >= snapshot.Metadata.Index {
+			log.Printf("state machine is at index %d, skipping snapshot at index %d", applied, snapshot.Metadata.Index)
+		} else if err := rc.restore(snapshot); err != nil {
+			return &raftError{op: "restore state machine from snapshot", err: err}
+		}
+	}
 
 	rc.transport = &rafthttp.Transport{
 		Logger:      rc.logger,
 		ID:          types.ID(rc.id),
-		ClusterID:   0x1000,
+		ClusterID:   rc.clusterID,
 		Raft:        rc,
+		Snapshotter: rc.snapshotter,
 		ServerStats: stats.NewServerStats("", ""),
 		LeaderStats: stats.NewLeaderStats(zap.NewExample(), strconv.Itoa(rc.id)),
 		ErrorC:      make(chan error),
 	}
+	if tlsInfo != nil {
+		rc.transport.TLSInfo = *tlsInfo
+	}
 
-	rc.transport.Start()
-	for i := range rc.peers {
-		if i+1 != rc.id {
-			rc.transport.AddPeer(types.ID(i+1), []string{rc.peers[i]})
+	if err := rc.transport.Start(); err != nil {
+		return &raftError{op: "start rafthttp", err: err}
+	}
+	// peers configured are the members unless the snapshot keeps them, conf changes
+	// replayed from the WAL update them
+	if members == nil {
+		for i, peer := range rc.peers {
+			members = append(members, member{ID: uint64(i + 1), memberContext: memberContext{PeerURL: peer}})
 		}
 	}
+	rc.setMembers(members)
 
-	go rc.serveRaft()
+	go rc.applyLoop()
+	go rc.proposeBatches()
+	go rc.serveRaft(ln)
 	go rc.serveChannels()
+	return nil
 }
 
 // stop closes http, closes all channels, and stops raft.
 func (rc *raftNode) stop() {
 	rc.stopHTTP()
-	close(rc.commitC)
+	close(rc.applyC)
 	close(rc.errorC)
 	rc.node.Stop()
 }
@@ -333,29 +631,222 @@ func (rc *raftNode) stopHTTP() {
 	<-rc.httpdonec
 }
 
-func (rc *raftNode) publishSnapshot(snapshotToSave raftpb.Snapshot) {
+func (rc *raftNode) publishSnapshot(snapshotToSave raftpb.Snapshot) error {
 	if raft.IsEmptySnap(snapshotToSave) {
-		return
+		return nil
 	}
 
 	log.Printf("publishing snapshot at index %d", rc.snapshotIndex)
 	defer log.Printf("finished publishing snapshot at index %d", rc.snapshotIndex)
 
 	if snapshotToSave.Metadata.Index <= rc.appliedIndex {
-		log.Fatalf("snapshot index [%d] should > progress.appliedIndex [%d]", snapshotToSave.Metadata.Index, rc.appliedIndex)
+		return &raftError{
+			op:  "publish snapshot",
+			err: fmt.Errorf("snapshot index [%d] should > progress.appliedIndex [%d]", snapshotToSave.Metadata.Index, rc.appliedIndex),
+		}
+	}
+	applyDoneC := make(chan struct{})
+	select {
+	case rc.applyC <- &applyBatch{snapshot: &snapshotToSave, index: snapshotToSave.Metadata.Index, applyDoneC: applyDoneC}:
+	case <-rc.stopc:
+		return nil
 	}
-	rc.commitC <- nil // trigger kvstore to load snapshot
 
+	rc.mu.Lock()
 	rc.confState = snapshotToSave.Metadata.ConfState
 	rc.snapshotIndex = snapshotToSave.Metadata.Index
 	rc.appliedIndex = snapshotToSave.Metadata.Index
+	rc.mu.Unlock()
+	rc.reportApplied()
+
+	members, err := snapshotMembers(snapshotToSave)
+	if err != nil {
+		return &raftError{op: "read members of snapshot", err: err}
+	}
+	if members != nil {
+		rc.setMembers(members)
+	}
+	return nil
 }
 
-var snapshotCatchUpEntriesN uint64 = 10000
+// reportApplied updates metrics of the applied index, it's called by the raft loop
+func (rc *raftNode) reportApplied() {
+	appliedIndexGauge.Set(float64(rc.appliedIndex))
+	if rc.commitIndex > rc.appliedIndex {
+		applyLag.Set(float64(rc.commitIndex - rc.appliedIndex))
+	} else {
+		applyLag.Set(0)
+	}
+}
 
-func (rc *raftNode) maybeTriggerSnapshot(applyDoneC <-chan struct{}) {
-	if rc.appliedIndex-rc.snapshotIndex <= rc.snapCount {
-		return
+// done is closed once the node stopped applying entries
+func (rc *raftNode) done() <-chan struct{} {
+	return rc.donec
+}
+
+// isLeader reports whether this node currently leads the cluster.
+func (rc *raftNode) isLeader() bool {
+	return rc.node.Status().Lead == uint64(rc.id)
+}
+
+// leader returns ID of the current leader and its gRPC address if it's known.
+// The ID is raft.None while there's no leader.
+func (rc *raftNode) leader() (uint64, string) {
+	lead := rc.node.Status().Lead
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+	return lead, rc.members[lead].ClientURL
+}
+
+// setClientURL records the gRPC address clients can reach the member at.
+func (rc *raftNode) setClientURL(id uint64, url string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	m := rc.members[id]
+	m.ClientURL = url
+	rc.members[id] = m
+}
+
+// status returns a point in time view of the raft node
+func (rc *raftNode) status() nodeStatus {
+	rs := rc.node.Status()
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+	st := nodeStatus{
+		ID:            uint64(rc.id),
+		Lead:          rs.Lead,
+		State:         rs.RaftState.String(),
+		Term:          rs.Term,
+		CommitIndex:   rs.Commit,
+		AppliedIndex:  rc.appliedIndex,
+		SnapshotIndex: rc.snapshotIndex,
+	}
+	if rc.applyErr != nil {
+		st.ApplyError = rc.applyErr.Error()
+	}
+	return st
+}
+
+// memberList returns members of the current configuration ordered by ID.
+func (rc *raftNode) memberList() []member {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+	members := make([]member, 0, len(rc.confState.Voters)+len(rc.confState.Learners))
+	for _, id := range rc.confState.Voters {
+		members = append(members, member{ID: id, memberContext: rc.members[id]})
+	}
+	for _, id := range rc.confState.Learners {
+		members = append(members, member{ID: id, memberContext: rc.members[id], Learner: true})
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].ID < members[j].ID })
+	return members
+}
+
+// transferLeadership hands leadership over to the target voter, or to the most
+// up-to-date follower if target is raft.None, and waits until the new leader
+// is confirmed. It returns ID of the new leader.
+func (rc *raftNode) transferLeadership(ctx context.Context, target uint64) (uint64, error) {
+	st := rc.node.Status()
+	switch {
+	case st.Lead == raft.None:
+		return raft.None, errNoLeader
+	case st.Lead != uint64(rc.id):
+		return raft.None, errNotLeader
+	case target == st.Lead:
+		return st.Lead, nil
+	}
+
+	if target == raft.None {
+		var match uint64
+		for id, pr := range st.Progress {
+			if id != st.Lead && !pr.IsLearner && (target == raft.None || pr.Match > match) {
+				target, match = id, pr.Match
+			}
+		}
+		if target == raft.None {
+			return raft.None, errInvalidTransferee
+		}
+	} else if pr, ok := st.Progress[target]; !ok || pr.IsLearner {
+		return raft.None, errInvalidTransferee
+	}
+
+	log.Printf("raftexample: transferring leadership from %d to %d", st.Lead, target)
+	rc.node.TransferLeadership(ctx, st.Lead, target)
+
+	ticker := time.NewTicker(leaderCheckInterval)
+	defer ticker.Stop()
+	for {
+		if lead := rc.node.Status().Lead; lead == target {
+			return lead, nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return raft.None, ctx.Err()
+		}
+	}
+}
+
+// checkPromotion verifies the learner can be promoted without putting the quorum
+// at risk. It has to be called on the leader as only the leader tracks progress
+// of its followers.
+func (rc *raftNode) checkPromotion(id uint64) error {
+	st := rc.node.Status()
+	switch {
+	case st.Lead == raft.None:
+		return errNoLeader
+	case st.Lead != uint64(rc.id):
+		return errNotLeader
+	}
+	learner, ok := st.Progress[id]
+	if !ok || !learner.IsLearner {
+		return errNotLearner
+	}
+	if leader := st.Progress[st.Lead]; float64(learner.Match) < float64(leader.Match)*readyLearnerMatchRatio {
+		return errLearnerNotReady
+	}
+	return nil
+}
+
+// linearizableRead confirms with a quorum that this node's view of the log is
+// current and waits until the state machine caught up with it, so it can serve
+// a linearizable read.
+func (rc *raftNode) linearizableRead(ctx context.Context) error {
+	if rc.node.Status().Lead == raft.None {
+		return errNoLeader
+	}
+
+	id := rc.readIDGen.Next()
+	rctx := make([]byte, 8)
+	binary.BigEndian.PutUint64(rctx, id)
+
+	ch := rc.readWait.Register(id)
+	if err := rc.node.ReadIndex(ctx, rctx); err != nil {
+		rc.readWait.Trigger(id, nil)
+		return err
+	}
+
+	var readIndex uint64
+	select {
+	case x := <-ch:
+		readIndex = x.(uint64)
+	case <-ctx.Done():
+		rc.readWait.Trigger(id, nil)
+		return ctx.Err()
+	}
+
+	// entries up to the read index have to be applied by the state machine first
+	select {
+	case <-rc.appliedWait.Wait(readIndex):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (rc *raftNode) maybeTriggerSnapshot(applyDoneC <-chan struct{}) error {
+	if rc.snapshotting || rc.appliedIndex-rc.snapshotIndex <= rc.cfg.SnapshotCount {
+		return nil
 	}
 
 	// wait until all committed entries are applied (or server is closed)
@@ -363,47 +854,194 @@ func (rc *raftNode) maybeTriggerSnapshot(applyDoneC <-chan struct{}) {
 		select {
 		case <-applyDoneC:
 		case <-rc.stopc:
-			return
+			return nil
+		}
+	}
+	// the state machine is behind the applied index if it failed
+	if rc.applyError() != nil {
+		return nil
+	}
+	return rc.startSnapshot()
+}
+
+// finalSnapshot takes a snapshot of all entries applied so far, so a restarted node
+// doesn't have to replay them from the WAL. It waits for the state machine to catch up
+// and for the snapshot to be written.
+func (rc *raftNode) finalSnapshot() error {
+	if rc.snapshotting {
+		if err := rc.finishSnapshot(<-rc.snapshotDoneC); err != nil {
+			return err
 		}
 	}
+	if rc.appliedIndex == rc.snapshotIndex {
+		return nil
+	}
+	select {
+	case <-rc.appliedWait.Wait(rc.appliedIndex):
+	case <-rc.applyFailedC:
+		log.Printf("raftexample: no final snapshot, the state machine failed (%v)", rc.applyError())
+		return nil
+	case <-rc.stopc:
+		return errStopped
+	}
+	if err := rc.startSnapshot(); err != nil {
+		return err
+	}
+	return rc.finishSnapshot(<-rc.snapshotDoneC)
+}
+
+// snapshotJob is a state machine snapshot written to its file off the raft loop,
+// together with the raft state at its index
+type snapshotJob struct {
+	index     uint64
+	confState raftpb.ConfState
+	members   []member
+	start     time.Time
+	err       error
+}
 
+// startSnapshot captures the state machine at the applied index, it has to have
+// applied all entries handed over. The capture is written to the snapshot file in the
+// background, the outcome is sent over snapshotDoneC.
+func (rc *raftNode) startSnapshot() error {
 	log.Printf("start snapshot [applied index: %d | last snapshot index: %d]", rc.appliedIndex, rc.snapshotIndex)
-	data, err := rc.getSnapshot()
+	job := snapshotJob{
+		index:     rc.appliedIndex,
+		confState: rc.confState,
+		members:   rc.memberList(),
+		start:     time.Now(),
+	}
+	write, err := rc.sm.Snapshot()
 	if err != nil {
-		log.Panic(err)
+		return &raftError{op: "take snapshot of state machine", err: err}
 	}
-	snap, err := rc.raftStorage.CreateSnapshot(rc.appliedIndex, &rc.confState, data)
+	rc.snapshotting = true
+	go func() {
+		job.err = rc.writeSnapshotFile(job.index, write)
+		rc.snapshotDoneC <- job
+	}()
+	return nil
+}
+
+// finishSnapshot saves the raft snapshot referring to the written snapshot file and
+// compacts the log. Snapshots older than one received from the leader meanwhile are
+// dropped.
+func (rc *raftNode) finishSnapshot(job snapshotJob) error {
+	rc.snapshotting = false
+	if job.err != nil {
+		return &raftError{op: "write snapshot of state machine", err: job.err}
+	}
+	ref, err := snapshotRef(job.members)
 	if err != nil {
-		panic(err)
+		return &raftError{op: "encode members of snapshot", err: err}
+	}
+	snap, err := rc.raftStorage.CreateSnapshot(job.index, &job.confState, ref)
+	if err == raft.ErrSnapOutOfDate {
+		log.Printf("dropped snapshot at index %d, a newer one has been applied", job.index)
+		return nil
+	}
+	if err != nil {
+		return &raftError{op: "create snapshot", err: err}
 	}
 	if err := rc.saveSnap(snap); err != nil {
-		panic(err)
+		return &raftError{op: "save snapshot", err: err}
 	}
 
 	compactIndex := uint64(1)
-	if rc.appliedIndex > snapshotCatchUpEntriesN {
-		compactIndex = rc.appliedIndex - snapshotCatchUpEntriesN
+	if job.index > rc.cfg.SnapshotCatchUpEntries {
+		compactIndex = job.index - rc.cfg.SnapshotCatchUpEntries
 	}
-	if err := rc.raftStorage.Compact(compactIndex); err != nil {
-		panic(err)
+	if err := rc.raftStorage.Compact(compactIndex); err != nil && err != raft.ErrCompacted {
+		return &raftError{op: "compact log", err: err}
 	}
 
 	log.Printf("compacted log at index %d", compactIndex)
-	rc.snapshotIndex = rc.appliedIndex
+	rc.mu.Lock()
+	rc.snapshotIndex = job.index
+	rc.mu.Unlock()
+	snapshotDurationSec.Observe(time.Since(job.start).Seconds())
+	return nil
+}
+
+// processReady persists and applies the ready state. It returns false once the
+// node has to stop, with the error if it failed.
+func (rc *raftNode) processReady(rd raft.Ready) (bool, error) {
+	if rd.SoftState != nil && rd.SoftState.Lead != rc.lead {
+		if rc.lead != raft.None || rd.SoftState.Lead != raft.None {
+			leaderChanges.Inc()
+		}
+		rc.lead = rd.SoftState.Lead
+		if rc.lead == uint64(rc.id) {
+			isLeaderGauge.Set(1)
+		} else {
+			isLeaderGauge.Set(0)
+		}
+	}
+	if !raft.IsEmptyHardState(rd.HardState) {
+		rc.commitIndex = rd.HardState.Commit
+	}
+
+	start := time.Now()
+	if err := rc.wal.Save(rd.HardState, rd.Entries); err != nil {
+		return false, &raftError{op: "save entries to wal", err: err}
+	}
+	walSaveDurationSec.Observe(time.Since(start).Seconds())
+	if !raft.IsEmptySnap(rd.Snapshot) {
+		if err := rc.saveSnap(rd.Snapshot); err != nil {
+			return false, &raftError{op: "save snapshot", err: err}
+		}
+		if err := rc.raftStorage.ApplySnapshot(rd.Snapshot); err != nil {
+			return false, &raftError{op: "apply snapshot to raft storage", err: err}
+		}
+		if err := rc.publishSnapshot(rd.Snapshot); err != nil {
+			return false, err
+		}
+	}
+	if err := rc.raftStorage.Append(rd.Entries); err != nil {
+		return false, &raftError{op: "append entries to raft storage", err: err}
+	}
+	rc.transport.Send(rc.sendSnapshots(rd.Messages))
+	for _, rs := range rd.ReadStates {
+		rc.readWait.Trigger(binary.BigEndian.Uint64(rs.RequestCtx), rs.Index)
+	}
+	ents, err := rc.entriesToApply(rd.CommittedEntries)
+	if err != nil {
+		return false, err
+	}
+	applyDoneC, ok := rc.publishEntries(ents)
+	if !ok {
+		return false, nil
+	}
+	if err := rc.maybeTriggerSnapshot(applyDoneC); err != nil {
+		return false, err
+	}
+	rc.node.Advance()
+	return true, nil
 }
 
 func (rc *raftNode) serveChannels() {
+	defer close(rc.stoppedc)
+	defer rc.wal.Close()
+	defer func() {
+		// the snapshot file is complete once the node stopped
+		if rc.snapshotting {
+			<-rc.snapshotDoneC
+		}
+	}()
+
 	snap, err := rc.raftStorage.Snapshot()
 	if err != nil {
-		panic(err)
+		rc.writeError(&raftError{op: "read snapshot", err: err})
+		return
 	}
+	rc.mu.Lock()
 	rc.confState = snap.Metadata.ConfState
 	rc.snapshotIndex = snap.Metadata.Index
 	rc.appliedIndex = snap.Metadata.Index
+	rc.mu.Unlock()
+	rc.appliedWait.Trigger(rc.appliedIndex)
 
-	defer rc.wal.Close()
-
-	ticker := time.NewTicker(100 * time.Millisecond)
+	ticker := time.NewTicker(rc.cfg.TickInterval)
 	defer ticker.Stop()
 
 	// send proposals over raft
@@ -416,17 +1054,30 @@ func (rc *raftNode) serveChannels() {
 				if !ok {
 					rc.proposeC = nil
 				} else {
-					// blocks until accepted by raft state machine
-					rc.node.Propose(context.TODO(), []byte(prop))
+					// batched with concurrent proposals, nobody waits for the result. The
+					// deadline keeps batches from waiting for a leader forever.
+					ctx, cancel := context.WithTimeout(context.Background(), defaultRequestTimeout)
+					select {
+					case rc.propc <- proposal{ctx: ctx, data: []byte(prop), cancel: cancel}:
+					case <-rc.donec:
+						cancel()
+					}
 				}
 
 			case cc, ok := <-rc.confChangeC:
 				if !ok {
 					rc.confChangeC = nil
 				} else {
-					confChangeCount++
-					cc.ID = confChangeCount
-					rc.node.ProposeConfChange(context.TODO(), cc)
+					if v1, ok := cc.(raftpb.ConfChange); ok {
+						confChangeCount++
+						v1.ID = confChangeCount
+						cc = v1
+					}
+					ctx, cancel := context.WithTimeout(context.Background(), defaultRequestTimeout)
+					if err := rc.node.ProposeConfChange(ctx, cc); err != nil {
+						log.Printf("raftexample: cannot propose conf change %v (%v)", cc, err)
+					}
+					cancel()
 				}
 			}
 		}
@@ -434,32 +1085,54 @@ func (rc *raftNode) serveChannels() {
 		close(rc.stopc)
 	}()
 
-	// event loop on raft state machine updates
+	// event loop on raft state machine updates. Ready is held back while a batch of
+	// proposals is handed over, until the next tick at most, so raft messages like
+	// votes aren't delayed for long.
+	var holding bool
 	for {
+		readyc := rc.node.Ready()
+		if holding {
+			readyc = nil
+		}
 		select {
 		case <-ticker.C:
+			holding = false
 			rc.node.Tick()
 
+		case <-rc.holdc:
+			holding = true
+
+		case <-rc.releasec:
+			holding = false
+
 		// store raft entries to wal, then publish over commit channel
-		case rd := <-rc.node.Ready():
-			rc.wal.Save(rd.HardState, rd.Entries)
-			if !raft.IsEmptySnap(rd.Snapshot) {
-				rc.saveSnap(rd.Snapshot)
-				rc.raftStorage.ApplySnapshot(rd.Snapshot)
-				rc.publishSnapshot(rd.Snapshot)
+		case rd := <-readyc:
+			ok, err := rc.processReady(rd)
+			if err != nil {
+				rc.writeError(err)
+				return
 			}
-			rc.raftStorage.Append(rd.Entries)
-			rc.transport.Send(rd.Messages)
-			applyDoneC, ok := rc.publishEntries(rc.entriesToApply(rd.CommittedEntries))
 			if !ok {
 				rc.stop()
 				return
 			}
-			rc.maybeTriggerSnapshot(applyDoneC)
-			rc.node.Advance()
+
+		case job := <-rc.snapshotDoneC:
+			if err := rc.finishSnapshot(job); err != nil {
+				rc.writeError(err)
+				return
+			}
+
+		case errc := <-rc.snapshotC:
+			err := rc.finalSnapshot()
+			errc <- err
+			if err != nil && err != errStopped {
+				rc.writeError(err)
+				return
+			}
 
 		case err := <-rc.transport.ErrorC:
-			rc.writeError(err)
+			rc.writeError(&raftError{op: "communicate with peers", err: err})
 			return
 
 		case <-rc.stopc:
@@ -469,22 +1142,21 @@ func (rc *raftNode) serveChannels() {
 	}
 }
 
-func (rc *raftNode) serveRaft() {
-	url, err := url.Parse(rc.peers[rc.id-1])
-	if err != nil {
-		log.Fatalf("raftexample: Failed parsing URL (%v)", err)
-	}
-
-	ln, err := newStoppableListener(url.Host, rc.httpstopc)
-	if err != nil {
-		log.Fatalf("raftexample: Failed to listen rafthttp (%v)", err)
-	}
-
-	err = (&http.Server{Handler: rc.transport.Handler()}).Serve(ln)
+// serveRaft serves the peer traffic on the listener until the node stops, a failure
+// is reported like the ones of the transport.
+func (rc *raftNode) serveRaft(ln net.Listener) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc(clusterIDPath, rc.serveClusterID)
+	mux.Handle("/", rc.transport.Handler())
+	err := (&http.Server{Handler: mux}).Serve(ln)
 	select {
 	case <-rc.httpstopc:
 	default:
-		log.Fatalf("raftexample: Failed to serve rafthttp (%v)", err)
+		select {
+		case rc.transport.ErrorC <- err:
+		case <-rc.httpstopc:
+		}
 	}
 	close(rc.httpdonec)
 }
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"go.etcd.io/etcd/client/pkg/v3/types"
+	"go.etcd.io/etcd/raft/v3"
+	"go.etcd.io/etcd/raft/v3/raftpb"
+	"go.etcd.io/etcd/server/v3/etcdserver/api/snap"
+)
+
+// State machine snapshots are streamed to the file of their index in the snapshot
+// directory, <index>.snap.db, so they neither have to fit into memory nor are kept
+// by the raft storage. Raft snapshots only refer to the file and keep the members,
+// as conf changes adding them get compacted with the log:
+//
+//	reference:  magic "RGRF" | version uint32 | members JSON
+//
+// Fixed size integers are big endian. Raft snapshots taken before hold the state
+// machine snapshot themselves, references of version 1 keep no members.
+const (
+	snapshotRefMagic   = "RGRF"
+	snapshotRefVersion = 2
+)
+
+// snapshotRef returns the raft snapshot data referring to the snapshot file
+func snapshotRef(members []member) ([]byte, error) {
+	data, err := json.Marshal(members)
+	if err != nil {
+		return nil, err
+	}
+	ref := binary.BigEndian.AppendUint32([]byte(snapshotRefMagic), snapshotRefVersion)
+	return append(ref, data...), nil
+}
+
+// isSnapshotRef tells if the raft snapshot data refers to a snapshot file
+func isSnapshotRef(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(snapshotRefMagic))
+}
+
+// snapshotMembers returns the members kept in the raft snapshot, nil if it keeps none
+func snapshotMembers(snapshot raftpb.Snapshot) ([]member, error) {
+	data := snapshot.Data
+	if !isSnapshotRef(data) {
+		return nil, nil
+	}
+	if len(data) < len(snapshotRefMagic)+4 {
+		return nil, fmt.Errorf("truncated snapshot reference")
+	}
+	switch version := binary.BigEndian.Uint32(data[len(snapshotRefMagic):]); {
+	case version > snapshotRefVersion:
+		return nil, fmt.Errorf("unsupported snapshot reference version %d", version)
+	case version < 2:
+		return nil, nil
+	}
+	var members []member
+	if err := json.Unmarshal(data[len(snapshotRefMagic)+4:], &members); err != nil {
+		return nil, fmt.Errorf("cannot decode members of snapshot: %w", err)
+	}
+	return members, nil
+}
+
+// writeSnapshotFile streams the state machine snapshot written by write to the file
+// of the index. The file is written under a temporary name and renamed once synced.
+func (rc *raftNode) writeSnapshotFile(index uint64, write func(w io.Writer) error) error {
+	pr, pw := io.Pipe()
+	snapErrC := make(chan error, 1)
+	go func() {
+		err := write(pw)
+		pw.CloseWithError(err)
+		snapErrC <- err
+	}()
+	_, err := rc.snapshotter.SaveDBFrom(pr, index)
+	// unblocks the state machine if the file couldn't be written
+	pr.CloseWithError(err)
+	if snapErr := <-snapErrC; snapErr != nil {
+		return snapErr
+	}
+	return err
+}
+
+// openSnapshotFile opens the snapshot file of the index and returns its size
+func (rc *raftNode) openSnapshotFile(index uint64) (*os.File, int64, error) {
+	path, err := rc.snapshotter.DBFilePath(index)
+	if err != nil {
+		return nil, 0, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, st.Size(), nil
+}
+
+// sendSnapshots sends snapshot messages referring to a snapshot file together with
+// the file, it's streamed from disk by rafthttp and saved to the snapshot directory
+// of the peer. The messages are dropped from the returned ones, rafthttp skips
+// messages to node 0.
+func (rc *raftNode) sendSnapshots(msgs []raftpb.Message) []raftpb.Message {
+	for i, m := range msgs {
+		if m.Type != raftpb.MsgSnap || !isSnapshotRef(m.Snapshot.Data) {
+			continue
+		}
+		msgs[i].To = 0
+		f, size, err := rc.openSnapshotFile(m.Snapshot.Metadata.Index)
+		if err != nil {
+			log.Printf("raftexample: cannot send snapshot at index %d to %d (%v)", m.Snapshot.Metadata.Index, m.To, err)
+			rc.node.ReportSnapshot(m.To, raft.SnapshotFailure)
+			continue
+		}
+		// rafthttp reports the outcome to raft and closes the file
+		rc.transport.SendSnapshot(*snap.NewMessage(m, f, size))
+	}
+	return msgs
+}
+
+// restore hands the snapshot data over to the state machine
+func (rc *raftNode) restore(snapshot raftpb.Snapshot) error {
+	index := snapshot.Metadata.Index
+	if !isSnapshotRef(snapshot.Data) {
+		return rc.sm.Restore(bytes.NewReader(snapshot.Data), index)
+	}
+	if _, err := snapshotMembers(snapshot); err != nil {
+		return err
+	}
+	f, _, err := rc.openSnapshotFile(index)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return rc.sm.Restore(f, index)
+}
+
+// setMembers replaces the members by the ones kept in a snapshot and has the transport
+// reach them. Members which got added without context keep the URLs known so far.
+func (rc *raftNode) setMembers(members []member) {
+	rc.mu.Lock()
+	old := rc.members
+	rc.members = make(map[uint64]memberContext, len(members))
+	for _, m := range members {
+		if m.PeerURL == "" {
+			m.memberContext = old[m.ID]
+		}
+		rc.members[m.ID] = m.memberContext
+	}
+	current := rc.members
+	rc.mu.Unlock()
+
+	for id := range old {
+		if _, ok := current[id]; !ok && rc.transport.Get(types.ID(id)) != nil {
+			rc.transport.RemovePeer(types.ID(id))
+		}
+	}
+	for id, m := range current {
+		switch {
+		case id == uint64(rc.id) || m.PeerURL == "":
+		case rc.transport.Get(types.ID(id)) == nil:
+			rc.transport.AddPeer(types.ID(id), []string{m.PeerURL})
+		case m.PeerURL != old[id].PeerURL:
+			rc.transport.UpdatePeer(types.ID(id), []string{m.PeerURL})
+		}
+	}
+}
@@ -1,43 +1,103 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"testing"
+	"time"
 
+	"go.etcd.io/etcd/raft/v3"
 	"go.etcd.io/etcd/raft/v3/raftpb"
 )
 
-func getSnapshotFn() (func() ([]byte, error), <-chan struct{}) {
-	snapshotTriggeredC := make(chan struct{})
-	return func() ([]byte, error) {
-		snapshotTriggeredC <- struct{}{}
-		return nil, nil
-	}, snapshotTriggeredC
+// commit is a batch of entries handed over to testStateMachine, it's applied once
+// applyDoneC gets closed
+type commit struct {
+	data       []string
+	applyDoneC chan struct{}
+}
+
+// testStateMachine publishes committed entries and waits for them to be acknowledged
+type testStateMachine struct {
+	commitC            chan *commit
+	snapshotTriggeredC chan struct{}
+	applyErr           error         // returned by Apply once the commit got acknowledged
+	restoredC          chan string   // gets restored snapshots if set
+	writeC             chan struct{} // snapshots are written once it's closed if set
+}
+
+func newTestStateMachine() *testStateMachine {
+	return &testStateMachine{
+		commitC:            make(chan *commit),
+		snapshotTriggeredC: make(chan struct{}),
+	}
+}
+
+func (sm *testStateMachine) Apply(entries []Entry) ([]Result, error) {
+	c := &commit{applyDoneC: make(chan struct{})}
+	for _, e := range entries {
+		c.data = append(c.data, string(e.Data))
+	}
+	sm.commitC <- c
+	<-c.applyDoneC
+	return nil, sm.applyErr
+}
+
+func (sm *testStateMachine) Snapshot() (func(w io.Writer) error, error) {
+	sm.snapshotTriggeredC <- struct{}{}
+	return func(w io.Writer) error {
+		if sm.writeC != nil {
+			<-sm.writeC
+		}
+		_, err := io.WriteString(w, "snapshot")
+		return err
+	}, nil
+}
+
+func (sm *testStateMachine) Restore(r io.Reader, _ uint64) error {
+	if sm.restoredC == nil {
+		return nil
+	}
+	data, err := io.ReadAll(r)
+	sm.restoredC <- string(data)
+	return err
+}
+
+func (sm *testStateMachine) AppliedIndex() uint64 {
+	return 0
 }
 
 type cluster struct {
 	peers              []string
+	nodes              []*raftNode
 	commitC            []<-chan *commit
 	errorC             []<-chan error
 	proposeC           []chan string
-	confChangeC        []chan raftpb.ConfChange
+	confChangeC        []chan raftpb.ConfChangeI
 	snapshotTriggeredC []<-chan struct{}
 }
 
 // newCluster creates a cluster of n nodes
-func newCluster(n int, dirPath string) *cluster {
+func newCluster(n int, dirPath string, cfg RaftConfig) *cluster {
+	scheme := "http"
+	if cfg.tlsInfo() != nil {
+		scheme = "https"
+	}
 	peers := make([]string, n)
 	for i := range peers {
-		peers[i] = fmt.Sprintf("http://127.0.0.1:%d", 10000+i)
+		peers[i] = fmt.Sprintf("%s://127.0.0.1:%d", scheme, 10000+i)
 	}
 
 	clus := &cluster{
 		peers:              peers,
+		nodes:              make([]*raftNode, len(peers)),
 		commitC:            make([]<-chan *commit, len(peers)),
 		errorC:             make([]<-chan error, len(peers)),
 		proposeC:           make([]chan string, len(peers)),
-		confChangeC:        make([]chan raftpb.ConfChange, len(peers)),
+		confChangeC:        make([]chan raftpb.ConfChangeI, len(peers)),
 		snapshotTriggeredC: make([]<-chan struct{}, len(peers)),
 	}
 
@@ -45,10 +105,15 @@ func newCluster(n int, dirPath string) *cluster {
 		os.RemoveAll(fmt.Sprintf("raftexample-%d", i+1))
 		os.RemoveAll(fmt.Sprintf("raftexample-%d-snap", i+1))
 		clus.proposeC[i] = make(chan string, 1)
-		clus.confChangeC[i] = make(chan raftpb.ConfChange, 1)
-		fn, snapshotTriggeredC := getSnapshotFn()
-		clus.snapshotTriggeredC[i] = snapshotTriggeredC
-		clus.commitC[i], clus.errorC[i], _ = newRaftNode(i+1, clus.peers, false, fn, clus.proposeC[i], clus.confChangeC[i], dirPath)
+		clus.confChangeC[i] = make(chan raftpb.ConfChangeI, 1)
+		sm := newTestStateMachine()
+		clus.commitC[i] = sm.commitC
+		clus.snapshotTriggeredC[i] = sm.snapshotTriggeredC
+		node, errorC, err := newRaftNode(i+1, clus.peers, false, cfg, sm, clus.proposeC[i], clus.confChangeC[i], dirPath)
+		if err != nil {
+			panic(err)
+		}
+		clus.nodes[i], clus.errorC[i] = node, errorC
 	}
 
 	return clus
@@ -58,8 +123,14 @@ func newCluster(n int, dirPath string) *cluster {
 func (clus *cluster) Close() (err error) {
 	for i := range clus.peers {
 		go func(i int) {
-			for range clus.commitC[i] {
-				// drain pending commits
+			for {
+				select {
+				case c := <-clus.commitC[i]:
+					// acknowledge pending commits
+					close(c.applyDoneC)
+				case <-clus.nodes[i].done():
+					return
+				}
 			}
 		}(i)
 		close(clus.proposeC[i])
@@ -67,6 +138,7 @@ func (clus *cluster) Close() (err error) {
 		if erri := <-clus.errorC[i]; erri != nil {
 			err = erri
 		}
+		<-clus.nodes[i].stopped()
 		// clean intermediates
 		os.RemoveAll(fmt.Sprintf("raftexample-%d", i+1))
 		os.RemoveAll(fmt.Sprintf("raftexample-%d-snap", i+1))
@@ -85,18 +157,16 @@ func (clus *cluster) closeNoErrors(t *testing.T) {
 // TestProposeOnCommit starts three nodes and feeds commits back into the proposal
 // channel. The intent is to ensure blocking on a proposal won't block raft progress.
 func Test_Raft_ProposeOnCommit(t *testing.T) {
-	clus := newCluster(3, t.TempDir())
+	clus := newCluster(3, t.TempDir(), defaultRaftConfig())
 	defer clus.closeNoErrors(t)
 
 	donec := make(chan struct{})
 	for i := range clus.peers {
 		// feedback for "n" committed entries, then update donec
-		go func(pC chan<- string, cC <-chan *commit, eC <-chan error) {
+		go func(n *raftNode, pC chan<- string, cC <-chan *commit, eC <-chan error) {
 			for n := 0; n < 100; n++ {
-				c, ok := <-cC
-				if !ok {
-					pC = nil
-				}
+				c := <-cC
+				close(c.applyDoneC)
 				select {
 				case pC <- c.data[0]:
 					continue
@@ -105,11 +175,17 @@ func Test_Raft_ProposeOnCommit(t *testing.T) {
 				}
 			}
 			donec <- struct{}{}
-			for range cC {
-				// acknowledge the commits from other nodes so
-				// raft continues to make progress
+			for {
+				select {
+				case c := <-cC:
+					// acknowledge the commits from other nodes so
+					// raft continues to make progress
+					close(c.applyDoneC)
+				case <-n.done():
+					return
+				}
 			}
-		}(clus.proposeC[i], clus.commitC[i], clus.errorC[i])
+		}(clus.nodes[i], clus.proposeC[i], clus.commitC[i], clus.errorC[i])
 
 		// one message feedback per node
 		go func(i int) { clus.proposeC[i] <- "foo" }(i)
@@ -122,7 +198,7 @@ func Test_Raft_ProposeOnCommit(t *testing.T) {
 
 // TestCloseProposerBeforeReplay tests closing the producer before raft starts.
 func Test_Raft_CloseProposerBeforeReplay(t *testing.T) {
-	clus := newCluster(1, t.TempDir())
+	clus := newCluster(1, t.TempDir(), defaultRaftConfig())
 	// close before replay so raft never starts
 	defer clus.closeNoErrors(t)
 }
@@ -130,7 +206,7 @@ func Test_Raft_CloseProposerBeforeReplay(t *testing.T) {
 // TestCloseProposerInflight tests closing the producer while
 // committed messages are being published to the client.
 func Test_Raft_CloseProposerInflight(t *testing.T) {
-	clus := newCluster(1, t.TempDir())
+	clus := newCluster(1, t.TempDir(), defaultRaftConfig())
 	defer clus.closeNoErrors(t)
 
 	// some inflight ops
@@ -140,14 +216,93 @@ func Test_Raft_CloseProposerInflight(t *testing.T) {
 	}()
 
 	// wait for one message
-	if c, ok := <-clus.commitC[0]; !ok || c.data[0] != "foo" {
+	c := <-clus.commitC[0]
+	close(c.applyDoneC)
+	if c.data[0] != "foo" {
 		t.Fatalf("Commit failed")
 	}
 }
 
+// TestProposeBatch tests proposals arriving within the batch interval are committed
+// together and keep their order.
+func Test_Raft_ProposeBatch(t *testing.T) {
+	cfg := defaultRaftConfig()
+	cfg.BatchInterval = 200 * time.Millisecond
+	clus := newCluster(1, t.TempDir(), cfg)
+	defer clus.closeNoErrors(t)
+
+	for !clus.nodes[0].isLeader() {
+		time.Sleep(leaderCheckInterval)
+	}
+	go func() {
+		for _, prop := range []string{"a", "b", "c"} {
+			clus.proposeC[0] <- prop
+		}
+	}()
+
+	for {
+		c := <-clus.commitC[0]
+		close(c.applyDoneC)
+		if len(c.data) == 0 || c.data[len(c.data)-1] == "" {
+			continue
+		}
+		if got := fmt.Sprint(c.data); got != "[a b c]" {
+			t.Fatalf("proposals expected to be committed in one batch, got %s", got)
+		}
+		return
+	}
+}
+
+// TestProposeBatchDropped tests proposers of a batch learn raft dropped their entries
+// instead of waiting for them.
+func Test_Raft_ProposeBatchDropped(t *testing.T) {
+	cfg := defaultRaftConfig()
+	cfg.BatchInterval = 200 * time.Millisecond
+	// the second entry of a batch exceeds the uncommitted size while the first is pending
+	cfg.MaxUncommittedEntriesSize = 10
+	clus := newCluster(1, t.TempDir(), cfg)
+	defer clus.closeNoErrors(t)
+
+	node := clus.nodes[0]
+	go func() {
+		for {
+			select {
+			case c := <-clus.commitC[0]:
+				close(c.applyDoneC)
+			case <-node.done():
+				return
+			}
+		}
+	}()
+	for !node.isLeader() {
+		time.Sleep(leaderCheckInterval)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	errc := make(chan error, 2)
+	for id := uint64(1); id <= 2; id++ {
+		go func(id uint64) {
+			_, err := node.Propose(ctx, id, []byte("8 bytes!"))
+			errc <- err
+		}(id)
+	}
+
+	// the accepted proposal times out as the test state machine returns no results
+	var dropped int
+	for i := 0; i < 2; i++ {
+		if err := <-errc; errors.Is(err, raft.ErrProposalDropped) {
+			dropped++
+		}
+	}
+	if dropped != 1 {
+		t.Fatalf("one proposal expected to be dropped, got %d", dropped)
+	}
+}
+
 // TestAddNewNode tests adding new node to the existing cluster.
 func Test_Raft_AddNewNode(t *testing.T) {
-	clus := newCluster(3, t.TempDir())
+	clus := newCluster(3, t.TempDir(), defaultRaftConfig())
 	defer clus.closeNoErrors(t)
 
 	os.RemoveAll("raftexample-4")
@@ -167,31 +322,45 @@ func Test_Raft_AddNewNode(t *testing.T) {
 	proposeC := make(chan string)
 	defer close(proposeC)
 
-	confChangeC := make(chan raftpb.ConfChange)
+	confChangeC := make(chan raftpb.ConfChangeI)
 	defer close(confChangeC)
 
-	newRaftNode(4, append(clus.peers, newNodeURL), true, nil, proposeC, confChangeC, t.TempDir())
+	sm := newTestStateMachine()
+	node, _, err := newRaftNode(4, append(clus.peers, newNodeURL), true, defaultRaftConfig(), sm, proposeC, confChangeC, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			select {
+			case c := <-sm.commitC:
+				close(c.applyDoneC)
+			case <-node.done():
+				return
+			}
+		}
+	}()
 
 	go func() {
 		proposeC <- "foo"
 	}()
 
-	if c, ok := <-clus.commitC[0]; !ok || c.data[0] != "foo" {
-		t.Fatalf("Commit failed")
+	// the conf change is committed first
+	for {
+		c := <-clus.commitC[0]
+		close(c.applyDoneC)
+		if len(c.data) > 0 && c.data[0] == "foo" {
+			break
+		}
 	}
 }
 
 func Test_Raft_Snapshot(t *testing.T) {
-	prevDefaultSnapshotCount := defaultSnapshotCount
-	prevSnapshotCatchUpEntriesN := snapshotCatchUpEntriesN
-	defaultSnapshotCount = 4
-	snapshotCatchUpEntriesN = 4
-	defer func() {
-		defaultSnapshotCount = prevDefaultSnapshotCount
-		snapshotCatchUpEntriesN = prevSnapshotCatchUpEntriesN
-	}()
+	cfg := defaultRaftConfig()
+	cfg.SnapshotCount = 4
+	cfg.SnapshotCatchUpEntries = 4
 
-	clus := newCluster(3, t.TempDir())
+	clus := newCluster(3, t.TempDir(), cfg)
 	defer clus.closeNoErrors(t)
 
 	go func() {
@@ -208,3 +377,416 @@ func Test_Raft_Snapshot(t *testing.T) {
 	close(c.applyDoneC)
 	<-clus.snapshotTriggeredC[0]
 }
+
+// TestSnapshotInBackground tests entries keep getting committed and applied while the
+// snapshot file is written, the log is compacted once it's been written.
+func Test_Raft_SnapshotInBackground(t *testing.T) {
+	cfg := defaultRaftConfig()
+	cfg.SnapshotCount = 2
+	cfg.SnapshotCatchUpEntries = 1
+
+	proposeC := make(chan string)
+	sm := newTestStateMachine()
+	sm.writeC = make(chan struct{})
+	node, errorC, err := newRaftNode(1, []string{"http://127.0.0.1:10061"}, false, cfg, sm, proposeC, make(chan raftpb.ConfChangeI), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		close(proposeC)
+		go func() {
+			for {
+				select {
+				case c := <-sm.commitC:
+					close(c.applyDoneC)
+				case <-sm.snapshotTriggeredC:
+				case <-node.done():
+					return
+				}
+			}
+		}()
+		for range errorC {
+		}
+		<-node.stopped()
+	}()
+
+	var triggered bool
+	propose := func(data string) {
+		proposeC <- data
+		for {
+			select {
+			case c := <-sm.commitC:
+				close(c.applyDoneC)
+				if len(c.data) > 0 && c.data[0] == data {
+					return
+				}
+			case <-sm.snapshotTriggeredC:
+				triggered = true
+			}
+		}
+	}
+	for i := 0; !triggered; i++ {
+		propose(fmt.Sprintf("foo-%d", i))
+	}
+
+	// the raft loop goes on while the snapshot is being written
+	propose("bar")
+	if index := node.status().SnapshotIndex; index != 0 {
+		t.Fatalf("snapshot expected to be saved once written, got one at index %d", index)
+	}
+	close(sm.writeC)
+	for deadline := time.Now().Add(time.Second); node.status().SnapshotIndex == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("snapshot expected to be saved once written")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if first, _ := node.raftStorage.FirstIndex(); first <= 1 {
+		t.Fatalf("log expected to be compacted, first index %d", first)
+	}
+}
+
+// TestSnapshotToNewNode tests the raft snapshot only refers to the snapshot file and
+// a node joining once the log got compacted is restored from the file of the leader.
+func Test_Raft_SnapshotToNewNode(t *testing.T) {
+	cfg := defaultRaftConfig()
+	cfg.SnapshotCount = 2
+	cfg.SnapshotCatchUpEntries = 1
+
+	clus := newCluster(1, t.TempDir(), cfg)
+	defer clus.closeNoErrors(t)
+	go func() {
+		for {
+			select {
+			case c := <-clus.commitC[0]:
+				close(c.applyDoneC)
+			case <-clus.snapshotTriggeredC[0]:
+			case <-clus.nodes[0].done():
+				return
+			}
+		}
+	}()
+
+	// added as a learner, so the cluster keeps its quorum until the node joins and
+	// snapshots taken afterwards include it
+	newNodeURL := "http://127.0.0.1:10001"
+	clus.confChangeC[0] <- raftpb.ConfChange{
+		Type:    raftpb.ConfChangeAddLearnerNode,
+		NodeID:  2,
+		Context: []byte(newNodeURL),
+	}
+
+	for len(clus.nodes[0].memberList()) < 2 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	for added := clus.nodes[0].status().AppliedIndex; clus.nodes[0].status().SnapshotIndex < added; {
+		clus.proposeC[0] <- "foo"
+		time.Sleep(10 * time.Millisecond)
+	}
+	snapshot, err := clus.nodes[0].raftStorage.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isSnapshotRef(snapshot.Data) {
+		t.Fatalf("snapshot expected to refer to its file, got %q", snapshot.Data)
+	}
+
+	proposeC := make(chan string)
+	defer close(proposeC)
+	confChangeC := make(chan raftpb.ConfChangeI)
+	defer close(confChangeC)
+
+	sm := newTestStateMachine()
+	sm.restoredC = make(chan string, 1)
+	node, _, err := newRaftNode(2, []string{clus.peers[0], newNodeURL}, true, cfg, sm, proposeC, confChangeC, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			select {
+			case c := <-sm.commitC:
+				close(c.applyDoneC)
+			case <-sm.snapshotTriggeredC:
+			case <-node.done():
+				return
+			}
+		}
+	}()
+
+	select {
+	case data := <-sm.restoredC:
+		if data != "snapshot" {
+			t.Fatalf("snapshot of the leader expected to be restored, got %q", data)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("new node expected to be restored from snapshot")
+	}
+}
+
+// TestMembersAfterRestart tests members added at runtime are reached again after a
+// restart once the snapshot covers the conf change adding them.
+func Test_Raft_MembersAfterRestart(t *testing.T) {
+	cfg := defaultRaftConfig()
+	cfg.SnapshotCount = 2
+	cfg.SnapshotCatchUpEntries = 1
+
+	dir := t.TempDir()
+	clus := newCluster(1, dir, cfg)
+	ack := func(sm <-chan *commit, snapshotTriggeredC <-chan struct{}, node *raftNode) {
+		for {
+			select {
+			case c := <-sm:
+				close(c.applyDoneC)
+			case <-snapshotTriggeredC:
+			case <-node.done():
+				return
+			}
+		}
+	}
+	go ack(clus.commitC[0], clus.snapshotTriggeredC[0], clus.nodes[0])
+
+	added := memberContext{PeerURL: "http://127.0.0.1:10001", ClientURL: "127.0.0.1:9101"}
+	data, err := added.marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clus.confChangeC[0] <- raftpb.ConfChange{Type: raftpb.ConfChangeAddLearnerNode, NodeID: 2, Context: data}
+	for len(clus.nodes[0].memberList()) < 2 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	for index := clus.nodes[0].status().AppliedIndex; clus.nodes[0].status().SnapshotIndex < index; {
+		clus.proposeC[0] <- "foo"
+		time.Sleep(10 * time.Millisecond)
+	}
+	clus.closeNoErrors(t)
+
+	proposeC := make(chan string)
+	sm := newTestStateMachine()
+	node, errorC, err := newRaftNode(1, clus.peers, false, cfg, sm, proposeC, make(chan raftpb.ConfChangeI), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		close(proposeC)
+		for range errorC {
+		}
+		<-node.stopped()
+	}()
+	go ack(sm.commitC, sm.snapshotTriggeredC, node)
+
+	// the configuration is loaded once the raft loop started
+	members := node.memberList()
+	for deadline := time.Now().Add(time.Second); len(members) == 0 && time.Now().Before(deadline); members = node.memberList() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(members) != 2 || members[1].ID != 2 || members[1].memberContext != added || !members[1].Learner {
+		t.Fatalf("member %+v expected to be restored from snapshot, got %+v", added, members)
+	}
+	if node.transport.Get(2) == nil {
+		t.Fatal("transport expected to reach the member added before the snapshot")
+	}
+}
+
+// TestApplyError tests a failing state machine isn't handed entries anymore while the
+// node keeps replicating them, the failure is reported by the status.
+func Test_Raft_ApplyError(t *testing.T) {
+	proposeC := make(chan string, 1)
+	confChangeC := make(chan raftpb.ConfChangeI)
+	defer close(confChangeC)
+
+	sm := newTestStateMachine()
+	sm.applyErr = errors.New("unsupported entry")
+	node, errorC, err := newRaftNode(1, []string{"http://127.0.0.1:10021"}, false, defaultRaftConfig(), sm, proposeC, confChangeC, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proposeC <- "foo"
+	c := <-sm.commitC
+	close(c.applyDoneC)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = node.Propose(ctx, 1, []byte("bar"))
+	var rerr *raftError
+	if !errors.As(err, &rerr) || !errors.Is(err, sm.applyErr) {
+		t.Fatalf("apply error expected, got %v", err)
+	}
+	// proposals fail right away, they're committed nevertheless
+	st := node.status()
+	for deadline := time.Now().Add(time.Second); st.CommitIndex < 3 && time.Now().Before(deadline); st = node.status() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if st.ApplyError != err.Error() || st.CommitIndex < 3 {
+		t.Fatalf("status expected to report the failure and entries committed since, got %+v", st)
+	}
+	select {
+	case c := <-sm.commitC:
+		t.Fatalf("entries %v handed over to the failed state machine", c.data)
+	case err := <-errorC:
+		t.Fatalf("node expected to keep running, got %v", err)
+	default:
+	}
+
+	close(proposeC)
+	if err, ok := <-errorC; ok {
+		t.Fatalf("node expected to stop without error, got %v", err)
+	}
+	<-node.stopped()
+}
+
+// TestStartError tests a node that can't start returns the error instead of exiting.
+func Test_Raft_StartError(t *testing.T) {
+	_, _, err := newRaftNode(1, []string{"http://127.0.0.1:port"}, false, defaultRaftConfig(), newTestStateMachine(), make(chan string), make(chan raftpb.ConfChangeI), t.TempDir())
+	var rerr *raftError
+	if !errors.As(err, &rerr) {
+		t.Fatalf("start error expected, got %v", err)
+	}
+}
+
+// TestGracefulShutdown tests a leader shutting down refuses proposals, hands leadership
+// over and takes a final snapshot.
+func Test_Raft_GracefulShutdown(t *testing.T) {
+	clus := newCluster(3, t.TempDir(), defaultRaftConfig())
+	defer clus.closeNoErrors(t)
+
+	for i := range clus.peers {
+		go func(i int) {
+			for {
+				select {
+				case c := <-clus.commitC[i]:
+					close(c.applyDoneC)
+				case <-clus.snapshotTriggeredC[i]:
+				case <-clus.nodes[i].done():
+					return
+				}
+			}
+		}(i)
+	}
+
+	var leader *raftNode
+	for leader == nil {
+		for _, node := range clus.nodes {
+			if node.isLeader() {
+				leader = node
+			}
+		}
+		time.Sleep(leaderCheckInterval)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := leader.shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := leader.Propose(ctx, 1, []byte("foo")); !errors.Is(err, errShuttingDown) {
+		t.Fatalf("proposal expected to be refused, got %v", err)
+	}
+	if lead, _ := leader.leader(); lead == raft.None || lead == uint64(leader.id) {
+		t.Fatalf("leadership expected to be handed over, leader is %d", lead)
+	}
+	snapshot, err := leader.snapshotter.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Metadata.Index == 0 {
+		t.Fatal("final snapshot expected to be saved")
+	}
+}
+
+// TestClusterID tests a node refuses to start with the WAL of another cluster and
+// nodes of clusters with different tokens don't accept each other's messages.
+func Test_Raft_ClusterID(t *testing.T) {
+	peers := []string{"http://127.0.0.1:10051", "http://127.0.0.1:10052"}
+	if clusterIDOf("a", peers) == clusterIDOf("b", peers) {
+		t.Fatal("cluster ID expected to depend on the token")
+	}
+	if clusterIDOf("a", peers) != clusterIDOf("a", []string{peers[1], peers[0]}) {
+		t.Fatal("cluster ID expected to be independent of the peer order")
+	}
+	if clusterIDOf("1", []string{"http://127.0.0.1:1005"}) == clusterIDOf("", []string{"http://127.0.0.1:10051"}) {
+		t.Fatal("cluster ID expected to separate peers from the token")
+	}
+
+	start := func(id int, peers []string, token, dir string) (*raftNode, func(), error) {
+		cfg := defaultRaftConfig()
+		cfg.ClusterToken = token
+		proposeC := make(chan string)
+		node, errorC, err := newRaftNode(id, peers, false, cfg, newTestStateMachine(), proposeC, make(chan raftpb.ConfChangeI), dir)
+		if err != nil {
+			return nil, nil, err
+		}
+		return node, func() {
+			close(proposeC)
+			for range errorC {
+			}
+			// the WAL gets closed once the raft loop returned
+			<-node.stopped()
+		}, nil
+	}
+
+	// restarted with the peers of a membership changed at runtime
+	dir := t.TempDir()
+	node, stop, err := start(1, []string{"http://127.0.0.1:10041"}, "a", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bootstrapped := node.clusterID
+	stop()
+	node, stop, err = start(1, []string{"http://127.0.0.1:10041", "http://127.0.0.1:10042"}, "a", dir)
+	if err != nil {
+		t.Fatalf("node expected to restart with changed peers, got %v", err)
+	}
+	stop()
+	if node.clusterID != bootstrapped {
+		t.Fatalf("cluster ID %s expected to be kept, got %s", bootstrapped, node.clusterID)
+	}
+
+	var nodes []*raftNode
+	for i, token := range []string{"a", "b"} {
+		node, stop, err := start(i+1, peers, token, t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stop()
+		nodes = append(nodes, node)
+	}
+	time.Sleep(3 * time.Second)
+	for _, node := range nodes {
+		if lead, _ := node.leader(); lead != raft.None {
+			t.Fatalf("leader %d elected across clusters", lead)
+		}
+	}
+}
+
+func Test_Raft_parseMemberContext(t *testing.T) {
+	tests := map[string]struct {
+		data []byte
+		want memberContext
+	}{
+		"peer URL only": {
+			data: []byte("http://127.0.0.1:10004"),
+			want: memberContext{PeerURL: "http://127.0.0.1:10004"},
+		},
+		"peer and client URL": {
+			data: []byte(`{"peerURL":"http://127.0.0.1:10004","clientURL":"127.0.0.1:10014"}`),
+			want: memberContext{PeerURL: "http://127.0.0.1:10004", ClientURL: "127.0.0.1:10014"},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := parseMemberContext(tt.data); got != tt.want {
+				t.Fatalf("expected %+v, got %+v", tt.want, got)
+			}
+			data, err := tt.want.marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := parseMemberContext(data); got != tt.want {
+				t.Fatalf("round trip expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
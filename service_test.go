@@ -2,21 +2,37 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/require"
 	"go.etcd.io/etcd/raft/v3/raftpb"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 
 	apiV1 "github/m-wrona/raft-go/model/api/v1"
+	apiV2 "github/m-wrona/raft-go/model/api/v2"
+	raftV1 "github/m-wrona/raft-go/model/raft/v1"
+	storeV1 "github/m-wrona/raft-go/model/store/v1"
 )
 
 func Test_Service_SingleNode_PutAndGetValue(t *testing.T) {
 	proposeC := make(chan string)
 	defer close(proposeC)
 
-	confChangeC := make(chan raftpb.ConfChange)
+	confChangeC := make(chan raftpb.ConfChangeI)
 	defer close(confChangeC)
 
 	clusters := []string{"http://127.0.0.1:9021"}
@@ -24,28 +40,49 @@ func Test_Service_SingleNode_PutAndGetValue(t *testing.T) {
 	defer sut.Server.Stop()
 
 	var wantValue uint32 = 2
-	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
-	defer cancel()
-	setValueResp, err := sut.KeyValueClient.Set(ctx, &apiV1.SetValueRequest{
-		Value: wantValue,
-	})
-	require.Nilf(t, err, "value not set: %s", err)
-	require.Truef(t, setValueResp.GetOk(), "value not set")
+	setValue(t, sut.KeyValueClient, wantValue)
 
 	assertValueEquals(t, sut.KeyValueClient, wantValue)
 }
 
+func Test_Service_SingleNode_SetReturnsOnceApplied(t *testing.T) {
+	proposeC := make(chan string)
+	defer close(proposeC)
+
+	confChangeC := make(chan raftpb.ConfChangeI)
+	defer close(confChangeC)
+
+	clusters := []string{"http://127.0.0.1:9161"}
+	sut := StartTestGrpcServer(1, clusters, proposeC, confChangeC, t.TempDir())
+	defer sut.Server.Stop()
+
+	firstResp := setValue(t, sut.KeyValueClient, 2)
+	require.NotZero(t, firstResp.GetIndex(), "commit index not returned")
+
+	for _, wantValue := range []uint32{3, 4, 5} {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		setValueResp, err := sut.KeyValueClient.Set(ctx, &apiV1.SetValueRequest{Value: wantValue})
+		require.Nilf(t, err, "value not set: %s", err)
+		require.Greater(t, setValueResp.GetIndex(), firstResp.GetIndex(), "commit index not increased")
+
+		getValueResp, err := sut.KeyValueClient.Get(ctx, &apiV1.GetValueRequest{})
+		cancel()
+		require.Nilf(t, err, "value not read: %s", err)
+		require.Equal(t, wantValue, getValueResp.GetValue(), "value read before set was applied")
+	}
+}
+
 func Test_Service_MultiNode_PutAndGetValue(t *testing.T) {
 	suts := make([]*TestServer, 0)
 	gr := sync.WaitGroup{}
 	mux := sync.Mutex{}
 
-	clusters := []string{"http://127.0.0.1:9021", "http://127.0.0.1:9022"}
+	clusters := []string{"http://127.0.0.1:9171", "http://127.0.0.1:9172"}
 	gr.Add(len(clusters))
 
 	for i := 1; i <= len(clusters); i++ {
 		proposeC := make(chan string)
-		confChangeC := make(chan raftpb.ConfChange)
+		confChangeC := make(chan raftpb.ConfChangeI)
 		id := i
 		go func() {
 			defer gr.Done()
@@ -58,26 +95,845 @@ func Test_Service_MultiNode_PutAndGetValue(t *testing.T) {
 	gr.Wait()
 
 	var wantValue uint32 = 2
-	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
-	setValueResp, err := suts[0].KeyValueClient.Set(ctx, &apiV1.SetValueRequest{
-		Value: wantValue,
-	})
-	cancel()
-	require.Nilf(t, err, "value not set: %s", err)
-	require.Truef(t, setValueResp.GetOk(), "value not set")
+	setValue(t, suts[0].KeyValueClient, wantValue)
 
 	assertValueEquals(t, suts[0].KeyValueClient, wantValue)
 	assertValueEquals(t, suts[1].KeyValueClient, wantValue)
 
 	var wantValue2 uint32 = 3
-	ctx2, cancel2 := context.WithTimeout(context.Background(), 200*time.Millisecond)
-	setValueResp2, err2 := suts[1].KeyValueClient.Set(ctx2, &apiV1.SetValueRequest{
-		Value: wantValue2,
-	})
-	cancel2()
-	require.Nilf(t, err2, "value not set: %s", err)
-	require.Truef(t, setValueResp2.GetOk(), "value not set")
+	setValue(t, suts[1].KeyValueClient, wantValue2)
+
+	// reads are linearizable so the other node must see the value straight away
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	getValueResp, err := suts[0].KeyValueClient.Get(ctx, &apiV1.GetValueRequest{})
+	cancel()
+	require.Nilf(t, err, "value not read: %s", err)
+	require.Equal(t, wantValue2, getValueResp.GetValue(), "stale value read")
 
 	assertValueEquals(t, suts[0].KeyValueClient, wantValue2)
 	assertValueEquals(t, suts[1].KeyValueClient, wantValue2)
 }
+
+func Test_Service_GetWithoutQuorum(t *testing.T) {
+	proposeC := make(chan string)
+	defer close(proposeC)
+
+	confChangeC := make(chan raftpb.ConfChangeI)
+	defer close(confChangeC)
+
+	// second node is never started so first one can't win an election
+	clusters := []string{"http://127.0.0.1:9031", "http://127.0.0.1:9032"}
+	sut := StartTestGrpcServer(1, clusters, proposeC, confChangeC, t.TempDir())
+	defer sut.Server.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := sut.KeyValueClient.Get(ctx, &apiV1.GetValueRequest{})
+	require.Equal(t, codes.Unavailable, status.Code(err), "unexpected error: %s", err)
+}
+
+func Test_Service_SingleNode_KeyedAPI(t *testing.T) {
+	proposeC := make(chan string)
+	defer close(proposeC)
+
+	confChangeC := make(chan raftpb.ConfChangeI)
+	defer close(confChangeC)
+
+	clusters := []string{"http://127.0.0.1:9041"}
+	sut := StartTestGrpcServer(1, clusters, proposeC, confChangeC, t.TempDir())
+	defer sut.Server.Stop()
+
+	// v1 API keeps working on top of the keyed one
+	setValue(t, sut.KeyValueClient, 7)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	getResp, err := sut.KeyValueV2Client.Get(ctx, &apiV2.GetRequest{Key: valueKey})
+	require.Nilf(t, err, "value not read: %s", err)
+	require.Equal(t, []byte("7"), getResp.GetValue())
+
+	for key, value := range map[string][]byte{"sensors/1/temp": []byte("21.5"), "sensors/2/temp": {0x00, 0xff}} {
+		putResp, err := sut.KeyValueV2Client.Put(ctx, &apiV2.PutRequest{Key: key, Value: value})
+		require.Nilf(t, err, "value not put: %s", err)
+		require.NotZero(t, putResp.GetIndex(), "commit index not returned")
+
+		getResp, err := sut.KeyValueV2Client.Get(ctx, &apiV2.GetRequest{Key: key})
+		require.Nilf(t, err, "value not read: %s", err)
+		require.Equal(t, value, getResp.GetValue())
+	}
+
+	_, err = sut.KeyValueV2Client.Get(ctx, &apiV2.GetRequest{Key: "sensors/3/temp"})
+	require.Equal(t, codes.NotFound, status.Code(err), "unexpected error: %s", err)
+
+	_, err = sut.KeyValueV2Client.Put(ctx, &apiV2.PutRequest{Value: []byte("1")})
+	require.Equal(t, codes.InvalidArgument, status.Code(err), "unexpected error: %s", err)
+
+	deleteResp, err := sut.KeyValueV2Client.Delete(ctx, &apiV2.DeleteRequest{Key: "sensors/1/temp"})
+	require.Nilf(t, err, "value not deleted: %s", err)
+	require.NotZero(t, deleteResp.GetIndex(), "commit index not returned")
+
+	_, err = sut.KeyValueV2Client.Get(ctx, &apiV2.GetRequest{Key: "sensors/1/temp"})
+	require.Equal(t, codes.NotFound, status.Code(err), "unexpected error: %s", err)
+
+	_, err = sut.KeyValueV2Client.Delete(ctx, &apiV2.DeleteRequest{Key: "sensors/1/temp"})
+	require.Equal(t, codes.NotFound, status.Code(err), "unexpected error: %s", err)
+
+	_, err = sut.KeyValueV2Client.Put(ctx, &apiV2.PutRequest{Key: valueKey, Value: []byte("8")})
+	require.Nilf(t, err, "value not put: %s", err)
+	getValueResp, err := sut.KeyValueClient.Get(ctx, &apiV1.GetValueRequest{})
+	require.Nilf(t, err, "value not read: %s", err)
+	require.Equal(t, uint32(8), getValueResp.GetValue())
+}
+
+func Test_Service_SingleNode_Range(t *testing.T) {
+	proposeC := make(chan string)
+	defer close(proposeC)
+
+	confChangeC := make(chan raftpb.ConfChangeI)
+	defer close(confChangeC)
+
+	clusters := []string{"http://127.0.0.1:9051"}
+	sut := StartTestGrpcServer(1, clusters, proposeC, confChangeC, t.TempDir())
+	defer sut.Server.Stop()
+
+	setValue(t, sut.KeyValueClient, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	wantKeys := make([]string, 0)
+	for i := 0; i < 5; i++ {
+		key := fmt.Sprintf("sensors/%d/temp", i)
+		_, err := sut.KeyValueV2Client.Put(ctx, &apiV2.PutRequest{Key: key, Value: []byte(key)})
+		require.Nilf(t, err, "value not put: %s", err)
+		wantKeys = append(wantKeys, key)
+	}
+
+	gotKeys := make([]string, 0)
+	request := &apiV2.RangeRequest{Prefix: "sensors/", Limit: 2}
+	for pages := 1; ; pages++ {
+		resp, err := sut.KeyValueV2Client.Range(ctx, request)
+		require.Nilf(t, err, "range not read: %s", err)
+		for _, kv := range resp.GetKvs() {
+			require.Equal(t, kv.GetKey(), string(kv.GetValue()))
+			gotKeys = append(gotKeys, kv.GetKey())
+		}
+		if resp.GetNextPageToken() == "" {
+			require.Equal(t, 3, pages, "unexpected number of pages")
+			break
+		}
+		request.PageToken = resp.GetNextPageToken()
+	}
+	require.Equal(t, wantKeys, gotKeys)
+
+	resp, err := sut.KeyValueV2Client.Range(ctx, &apiV2.RangeRequest{StartKey: "sensors/1", EndKey: "sensors/3"})
+	require.Nilf(t, err, "range not read: %s", err)
+	require.Len(t, resp.GetKvs(), 2)
+	require.Empty(t, resp.GetNextPageToken())
+
+	_, err = sut.KeyValueV2Client.Range(ctx, &apiV2.RangeRequest{Prefix: "sensors/", StartKey: "a"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err), "unexpected error: %s", err)
+
+	_, err = sut.KeyValueV2Client.Range(ctx, &apiV2.RangeRequest{Prefix: "sensors/", PageToken: "devices"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err), "unexpected error: %s", err)
+}
+
+func Test_Service_SingleNode_Watch(t *testing.T) {
+	proposeC := make(chan string)
+	defer close(proposeC)
+
+	confChangeC := make(chan raftpb.ConfChangeI)
+	defer close(confChangeC)
+
+	clusters := []string{"http://127.0.0.1:9061"}
+	sut := StartTestGrpcServer(1, clusters, proposeC, confChangeC, t.TempDir())
+	defer sut.Server.Stop()
+
+	setValue(t, sut.KeyValueClient, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	firstPut, err := sut.KeyValueV2Client.Put(ctx, &apiV2.PutRequest{Key: "sensors/1/temp", Value: []byte("20")})
+	require.Nilf(t, err, "value not put: %s", err)
+
+	stream, err := sut.KeyValueV2Client.Watch(ctx, &apiV2.WatchRequest{Key: "sensors/", Prefix: true, StartIndex: firstPut.GetIndex()})
+	require.Nilf(t, err, "watch not created: %s", err)
+	resp, err := stream.Recv()
+	require.Nilf(t, err, "watch not created: %s", err)
+	require.True(t, resp.GetCreated(), "watch not created")
+
+	_, err = sut.KeyValueV2Client.Put(ctx, &apiV2.PutRequest{Key: "devices/1", Value: []byte("on")})
+	require.Nilf(t, err, "value not put: %s", err)
+	deleteResp, err := sut.KeyValueV2Client.Delete(ctx, &apiV2.DeleteRequest{Key: "sensors/1/temp"})
+	require.Nilf(t, err, "value not deleted: %s", err)
+
+	wantEvents := []*apiV2.Event{
+		{Type: apiV2.Event_PUT, Key: "sensors/1/temp", Value: []byte("20"), Index: firstPut.GetIndex()},
+		{Type: apiV2.Event_DELETE, Key: "sensors/1/temp", Index: deleteResp.GetIndex()},
+	}
+	for _, want := range wantEvents {
+		resp, err := stream.Recv()
+		require.Nilf(t, err, "event not received: %s", err)
+		require.Len(t, resp.GetEvents(), 1)
+		got := resp.GetEvents()[0]
+		require.Equal(t, want.GetType(), got.GetType())
+		require.Equal(t, want.GetKey(), got.GetKey())
+		require.Equal(t, want.GetValue(), got.GetValue())
+		require.Equal(t, want.GetIndex(), got.GetIndex())
+	}
+
+	invalid, err := sut.KeyValueV2Client.Watch(ctx, &apiV2.WatchRequest{})
+	require.Nilf(t, err, "watch not sent: %s", err)
+	_, err = invalid.Recv()
+	require.Equal(t, codes.InvalidArgument, status.Code(err), "unexpected error: %s", err)
+}
+
+func Test_Service_SingleNode_TxnCounter(t *testing.T) {
+	proposeC := make(chan string)
+	defer close(proposeC)
+
+	confChangeC := make(chan raftpb.ConfChangeI)
+	defer close(confChangeC)
+
+	clusters := []string{"http://127.0.0.1:9071"}
+	sut := StartTestGrpcServer(1, clusters, proposeC, confChangeC, t.TempDir())
+	defer sut.Server.Stop()
+
+	setValue(t, sut.KeyValueClient, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// concurrent read-modify-write through compare-and-swap on the modification index
+	const workers, increments = 4, 5
+	gr := sync.WaitGroup{}
+	gr.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer gr.Done()
+			for i := 0; i < increments; {
+				var counter int
+				var modIndex uint64
+				getResp, err := sut.KeyValueV2Client.Get(ctx, &apiV2.GetRequest{Key: "counter"})
+				if err == nil {
+					counter, _ = strconv.Atoi(string(getResp.GetValue()))
+					modIndex = getResp.GetModIndex()
+				} else if status.Code(err) != codes.NotFound {
+					t.Errorf("counter not read: %s", err)
+					return
+				}
+
+				txnResp, err := sut.KeyValueV2Client.Txn(ctx, &apiV2.TxnRequest{
+					Compare: []*apiV2.Compare{{
+						Key:      "counter",
+						Target:   apiV2.Compare_MOD_INDEX,
+						Result:   apiV2.Compare_EQUAL,
+						ModIndex: modIndex,
+					}},
+					Success: []*apiV2.RequestOp{{Request: &apiV2.RequestOp_Put{Put: &apiV2.PutRequest{
+						Key:   "counter",
+						Value: []byte(strconv.Itoa(counter + 1)),
+					}}}},
+				})
+				if err != nil {
+					t.Errorf("transaction not committed: %s", err)
+					return
+				}
+				if txnResp.GetSucceeded() {
+					i++
+				}
+			}
+		}()
+	}
+	gr.Wait()
+
+	getResp, err := sut.KeyValueV2Client.Get(ctx, &apiV2.GetRequest{Key: "counter"})
+	require.Nilf(t, err, "counter not read: %s", err)
+	require.Equal(t, strconv.Itoa(workers*increments), string(getResp.GetValue()))
+
+	_, err = sut.KeyValueV2Client.Txn(ctx, &apiV2.TxnRequest{
+		Success: []*apiV2.RequestOp{{}},
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err), "unexpected error: %s", err)
+}
+
+func Test_Service_SingleNode_LeaseExpiry(t *testing.T) {
+	proposeC := make(chan string)
+	defer close(proposeC)
+
+	confChangeC := make(chan raftpb.ConfChangeI)
+	defer close(confChangeC)
+
+	clusters := []string{"http://127.0.0.1:9081"}
+	sut := StartTestGrpcServer(1, clusters, proposeC, confChangeC, t.TempDir())
+	defer sut.Server.Stop()
+
+	// wait for the node to become a leader
+	setValue(t, sut.KeyValueClient, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := sut.LeaseClient.Grant(ctx, &apiV2.LeaseGrantRequest{})
+	require.Equal(t, codes.InvalidArgument, status.Code(err), "unexpected error: %s", err)
+
+	_, err = sut.KeyValueV2Client.Put(ctx, &apiV2.PutRequest{Key: "devices/0/presence", Value: []byte("online"), Lease: 404})
+	require.Equal(t, codes.NotFound, status.Code(err), "unexpected error: %s", err)
+
+	short, err := sut.LeaseClient.Grant(ctx, &apiV2.LeaseGrantRequest{Ttl: 1})
+	require.Nilf(t, err, "lease not granted: %s", err)
+	require.NotZero(t, short.GetId(), "lease ID not generated")
+
+	kept, err := sut.LeaseClient.Grant(ctx, &apiV2.LeaseGrantRequest{Ttl: 2, Id: 42})
+	require.Nilf(t, err, "lease not granted: %s", err)
+	require.Equal(t, int64(42), kept.GetId())
+
+	_, err = sut.LeaseClient.Grant(ctx, &apiV2.LeaseGrantRequest{Ttl: 2, Id: 42})
+	require.Equal(t, codes.AlreadyExists, status.Code(err), "unexpected error: %s", err)
+
+	_, err = sut.KeyValueV2Client.Put(ctx, &apiV2.PutRequest{Key: "devices/1/presence", Value: []byte("online"), Lease: short.GetId()})
+	require.Nilf(t, err, "value not put: %s", err)
+	_, err = sut.KeyValueV2Client.Put(ctx, &apiV2.PutRequest{Key: "devices/2/presence", Value: []byte("online"), Lease: kept.GetId()})
+	require.Nilf(t, err, "value not put: %s", err)
+
+	// keep the second lease alive past both TTLs
+	for i := 0; i < 6; i++ {
+		keepAliveResp, err := sut.LeaseClient.KeepAlive(ctx, &apiV2.LeaseKeepAliveRequest{Id: kept.GetId()})
+		require.Nilf(t, err, "lease not kept alive: %s", err)
+		require.Equal(t, int64(2), keepAliveResp.GetTtl())
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	_, err = sut.KeyValueV2Client.Get(ctx, &apiV2.GetRequest{Key: "devices/1/presence"})
+	require.Equal(t, codes.NotFound, status.Code(err), "unexpected error: %s", err)
+	_, err = sut.LeaseClient.KeepAlive(ctx, &apiV2.LeaseKeepAliveRequest{Id: short.GetId()})
+	require.Equal(t, codes.NotFound, status.Code(err), "unexpected error: %s", err)
+
+	getResp, err := sut.KeyValueV2Client.Get(ctx, &apiV2.GetRequest{Key: "devices/2/presence"})
+	require.Nilf(t, err, "value not read: %s", err)
+	require.Equal(t, []byte("online"), getResp.GetValue())
+
+	_, err = sut.LeaseClient.Revoke(ctx, &apiV2.LeaseRevokeRequest{Id: kept.GetId()})
+	require.Nilf(t, err, "lease not revoked: %s", err)
+	_, err = sut.KeyValueV2Client.Get(ctx, &apiV2.GetRequest{Key: "devices/2/presence"})
+	require.Equal(t, codes.NotFound, status.Code(err), "unexpected error: %s", err)
+}
+
+func Test_Service_MultiNode_ForwardToLeader(t *testing.T) {
+	suts := make([]*TestServer, 2)
+	gr := sync.WaitGroup{}
+
+	clusters := []string{"http://127.0.0.1:9091", "http://127.0.0.1:9092"}
+	gr.Add(len(clusters))
+
+	for i := 1; i <= len(clusters); i++ {
+		proposeC := make(chan string)
+		confChangeC := make(chan raftpb.ConfChangeI)
+		id := i
+		go func() {
+			defer gr.Done()
+			suts[id-1] = StartTestGrpcServer(id, clusters, proposeC, confChangeC, t.TempDir())
+		}()
+	}
+	gr.Wait()
+
+	for _, sut := range suts {
+		for i, member := range suts {
+			sut.Node.setClientURL(uint64(i+1), member.Url)
+		}
+	}
+
+	setValue(t, suts[0].KeyValueClient, 1)
+
+	leader, follower := suts[0], suts[1]
+	if !leader.Node.isLeader() {
+		leader, follower = follower, leader
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	putResp, err := follower.KeyValueV2Client.Put(ctx, &apiV2.PutRequest{Key: "sensors/1/temp", Value: []byte("21.5")})
+	require.Nilf(t, err, "value not forwarded: %s", err)
+	require.NotZero(t, putResp.GetIndex(), "commit index not returned")
+
+	getResp, err := leader.KeyValueV2Client.Get(ctx, &apiV2.GetRequest{Key: "sensors/1/temp"})
+	require.Nilf(t, err, "value not read: %s", err)
+	require.Equal(t, []byte("21.5"), getResp.GetValue())
+
+	// clients can ask for the leader hint instead
+	noForwardCtx := metadata.AppendToOutgoingContext(ctx, noForwardHeader, "true")
+	_, err = follower.KeyValueV2Client.Put(noForwardCtx, &apiV2.PutRequest{Key: "sensors/2/temp", Value: []byte("19")})
+	st := status.Convert(err)
+	require.Equal(t, codes.FailedPrecondition, st.Code(), "unexpected error: %s", err)
+	require.Len(t, st.Details(), 1, "leader hint not returned")
+	info, ok := st.Details()[0].(*errdetails.ErrorInfo)
+	require.True(t, ok, "unexpected error details: %v", st.Details())
+	require.Equal(t, notLeaderReason, info.GetReason())
+	require.Equal(t, leader.Url, info.GetMetadata()["leader_url"])
+	require.Equal(t, strconv.Itoa(leader.Node.id), info.GetMetadata()["leader_id"])
+
+	// leader serves the requests itself
+	_, err = leader.KeyValueV2Client.Put(noForwardCtx, &apiV2.PutRequest{Key: "sensors/2/temp", Value: []byte("19")})
+	require.Nilf(t, err, "value not put: %s", err)
+}
+
+func Test_Service_AddNode(t *testing.T) {
+	proposeC := make(chan string)
+	confChangeC := make(chan raftpb.ConfChangeI)
+
+	sut := StartTestGrpcServer(1, []string{"http://127.0.0.1:9101"}, proposeC, confChangeC, t.TempDir())
+	setValue(t, sut.KeyValueClient, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, request := range []*raftV1.NodeRequest{
+		{PeerUrl: "http://127.0.0.1:9102"},
+		{Id: 2},
+		{Id: 2, PeerUrl: "127.0.0.1:9102"},
+		{Id: 2, PeerUrl: "http://127.0.0.1:9102", ClientUrl: "http://127.0.0.1:9112"},
+	} {
+		_, err := sut.RaftClient.Add(ctx, request)
+		require.Equal(t, codes.InvalidArgument, status.Code(err), "unexpected error for %v: %s", request, err)
+	}
+
+	clusters := []string{"http://127.0.0.1:9101", "http://127.0.0.1:9102"}
+	_, err := sut.RaftClient.Add(ctx, &raftV1.NodeRequest{Id: 2, PeerUrl: clusters[1], ClientUrl: "127.0.0.1:9112"})
+	require.Nilf(t, err, "node not added: %s", err)
+
+	// replies come once conf changes are applied
+	membersResp, err := sut.RaftClient.MemberList(ctx, &raftV1.MemberListRequest{})
+	require.Nilf(t, err, "members not listed: %s", err)
+	require.Len(t, membersResp.GetMembers(), 2)
+
+	newNode := StartTestGrpcServer(2, clusters, make(chan string), make(chan raftpb.ConfChangeI), t.TempDir())
+
+	// quorum needs the new node now, so it's reachable once writes get committed
+	setValue(t, sut.KeyValueClient, 2)
+	assertValueEquals(t, newNode.KeyValueClient, 2)
+
+	// conf changes not fitting the members are refused
+	_, err = sut.RaftClient.Add(ctx, &raftV1.NodeRequest{Id: 2, PeerUrl: clusters[1]})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err), "unexpected error: %s", err)
+	_, err = sut.RaftClient.Remove(ctx, &raftV1.NodeRequest{Id: 7})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err), "unexpected error: %s", err)
+
+	wantMembers := []*raftV1.Member{
+		{Id: 1, PeerUrl: clusters[0]},
+		{Id: 2, PeerUrl: clusters[1], ClientUrl: "127.0.0.1:9112"},
+	}
+	for _, s := range []*TestServer{sut, newNode} {
+		membersResp, err := s.RaftClient.MemberList(ctx, &raftV1.MemberListRequest{})
+		require.Nilf(t, err, "members not listed: %s", err)
+		require.Len(t, membersResp.GetMembers(), len(wantMembers))
+		for i, m := range membersResp.GetMembers() {
+			require.Truef(t, proto.Equal(wantMembers[i], m), "expected member %v, got %v", wantMembers[i], m)
+		}
+
+		statusResp, err := s.RaftClient.Status(ctx, &raftV1.StatusRequest{})
+		require.Nilf(t, err, "status not read: %s", err)
+		require.Equal(t, uint64(1), statusResp.GetLeader())
+		require.NotZero(t, statusResp.GetTerm())
+		require.NotZero(t, statusResp.GetAppliedIndex())
+		require.GreaterOrEqual(t, statusResp.GetCommitIndex(), statusResp.GetAppliedIndex())
+	}
+
+	statusResp, err := sut.RaftClient.Status(ctx, &raftV1.StatusRequest{})
+	require.Nilf(t, err, "status not read: %s", err)
+	require.Equal(t, uint64(1), statusResp.GetId())
+	require.Equal(t, "StateLeader", statusResp.GetState())
+}
+
+func Test_Service_MultiNode_TransferLeadership(t *testing.T) {
+	suts := make([]*TestServer, 2)
+	gr := sync.WaitGroup{}
+
+	clusters := []string{"http://127.0.0.1:9131", "http://127.0.0.1:9132"}
+	gr.Add(len(clusters))
+
+	for i := 1; i <= len(clusters); i++ {
+		proposeC := make(chan string)
+		confChangeC := make(chan raftpb.ConfChangeI)
+		id := i
+		go func() {
+			defer gr.Done()
+			suts[id-1] = StartTestGrpcServer(id, clusters, proposeC, confChangeC, t.TempDir())
+		}()
+	}
+	gr.Wait()
+
+	for _, sut := range suts {
+		for i, member := range suts {
+			sut.Node.setClientURL(uint64(i+1), member.Url)
+		}
+	}
+
+	setValue(t, suts[0].KeyValueClient, 1)
+
+	leader, follower := suts[0], suts[1]
+	if !leader.Node.isLeader() {
+		leader, follower = follower, leader
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := leader.RaftClient.TransferLeadership(ctx, &raftV1.TransferLeadershipRequest{Target: 7})
+	require.Equal(t, codes.InvalidArgument, status.Code(err), "unexpected error: %s", err)
+
+	// followers hand the request over to the leader which picks the follower itself
+	transferResp, err := follower.RaftClient.TransferLeadership(ctx, &raftV1.TransferLeadershipRequest{})
+	require.Nilf(t, err, "leadership not transferred: %s", err)
+	require.Equal(t, uint64(follower.Node.id), transferResp.GetLeader())
+	require.True(t, follower.Node.isLeader(), "new leader not confirmed")
+
+	transferResp, err = follower.RaftClient.TransferLeadership(ctx, &raftV1.TransferLeadershipRequest{Target: uint64(leader.Node.id)})
+	require.Nilf(t, err, "leadership not transferred: %s", err)
+	require.Equal(t, uint64(leader.Node.id), transferResp.GetLeader())
+
+	setValue(t, follower.KeyValueClient, 2)
+	assertValueEquals(t, leader.KeyValueClient, 2)
+}
+
+func Test_Service_AddAndPromoteLearner(t *testing.T) {
+	proposeC := make(chan string)
+	confChangeC := make(chan raftpb.ConfChangeI)
+
+	sut := StartTestGrpcServer(1, []string{"http://127.0.0.1:9141"}, proposeC, confChangeC, t.TempDir())
+	setValue(t, sut.KeyValueClient, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	clusters := []string{"http://127.0.0.1:9141", "http://127.0.0.1:9142"}
+	_, err := sut.RaftClient.AddLearner(ctx, &raftV1.NodeRequest{Id: 2, PeerUrl: clusters[1]})
+	require.Nilf(t, err, "learner not added: %s", err)
+
+	// learner doesn't count into quorum so writes go through while it's down
+	setValue(t, sut.KeyValueClient, 2)
+
+	_, err = sut.RaftClient.Promote(ctx, &raftV1.NodeRequest{Id: 1})
+	require.Equal(t, codes.InvalidArgument, status.Code(err), "unexpected error: %s", err)
+	_, err = sut.RaftClient.Promote(ctx, &raftV1.NodeRequest{Id: 2})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err), "unexpected error: %s", err)
+
+	learner := StartTestGrpcServer(2, clusters, make(chan string), make(chan raftpb.ConfChangeI), t.TempDir())
+	assertValueEquals(t, learner.KeyValueClient, 2)
+
+	membersResp, err := learner.RaftClient.MemberList(ctx, &raftV1.MemberListRequest{})
+	require.Nilf(t, err, "members not listed: %s", err)
+	require.Len(t, membersResp.GetMembers(), 2)
+	require.False(t, membersResp.GetMembers()[0].GetLearner(), "leader listed as learner")
+	require.True(t, membersResp.GetMembers()[1].GetLearner(), "learner listed as voter")
+
+	for i := 0; i < 50; i++ {
+		if _, err = sut.RaftClient.Promote(ctx, &raftV1.NodeRequest{Id: 2}); status.Code(err) != codes.FailedPrecondition {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	require.Nilf(t, err, "learner not promoted: %s", err)
+
+	membersResp, err = sut.RaftClient.MemberList(ctx, &raftV1.MemberListRequest{})
+	require.Nilf(t, err, "members not listed: %s", err)
+	require.False(t, membersResp.GetMembers()[1].GetLearner(), "learner not promoted")
+	_, err = sut.RaftClient.AddLearner(ctx, &raftV1.NodeRequest{Id: 2, PeerUrl: clusters[1]})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err), "unexpected error: %s", err)
+
+	setValue(t, sut.KeyValueClient, 3)
+	assertValueEquals(t, learner.KeyValueClient, 3)
+}
+
+func Test_Service_SingleNode_DiskBackend(t *testing.T) {
+	proposeC := make(chan string)
+	defer close(proposeC)
+
+	confChangeC := make(chan raftpb.ConfChangeI)
+	defer close(confChangeC)
+
+	dir := t.TempDir()
+	b, err := openBoltBackend(filepath.Join(dir, "store.db"))
+	require.Nilf(t, err, "backend not opened: %s", err)
+
+	clusters := []string{"http://127.0.0.1:9151"}
+	sut := StartTestGrpcServerWithBackend(1, clusters, proposeC, confChangeC, dir, b)
+	defer sut.Server.Stop()
+
+	setValue(t, sut.KeyValueClient, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	for _, key := range []string{"sensors/1/temp", "sensors/2/temp", "devices/1"} {
+		_, err := sut.KeyValueV2Client.Put(ctx, &apiV2.PutRequest{Key: key, Value: []byte(key)})
+		require.Nilf(t, err, "value not put: %s", err)
+	}
+	deleteResp, err := sut.KeyValueV2Client.Delete(ctx, &apiV2.DeleteRequest{Key: "sensors/2/temp"})
+	require.Nilf(t, err, "value not deleted: %s", err)
+
+	rangeResp, err := sut.KeyValueV2Client.Range(ctx, &apiV2.RangeRequest{Prefix: "sensors/"})
+	require.Nilf(t, err, "range not read: %s", err)
+	require.Len(t, rangeResp.GetKvs(), 1)
+	require.Equal(t, "sensors/1/temp", rangeResp.GetKvs()[0].GetKey())
+
+	_, err = sut.KeyValueV2Client.Put(ctx, &apiV2.PutRequest{Key: strings.Repeat("k", maxKeySize+1), Value: []byte("1")})
+	require.Equal(t, codes.InvalidArgument, status.Code(err), "unexpected error: %s", err)
+
+	require.Equal(t, deleteResp.GetIndex(), b.appliedIndex(), "applied index not persisted")
+}
+
+func Test_Service_SingleNode_UnsupportedCommand(t *testing.T) {
+	proposeC := make(chan string)
+	defer close(proposeC)
+
+	confChangeC := make(chan raftpb.ConfChangeI)
+	defer close(confChangeC)
+
+	clusters := []string{"http://127.0.0.1:9121"}
+	sut := StartTestGrpcServer(1, clusters, proposeC, confChangeC, t.TempDir())
+	defer sut.Server.Stop()
+
+	setValue(t, sut.KeyValueClient, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// a command of a newer version, proposed by an upgraded member
+	newer, err := proto.Marshal(&storeV1.Command{Version: commandVersion + 1, Op: storeV1.Command_PUT, Id: 1})
+	require.Nilf(t, err, "command not encoded: %s", err)
+	_, err = sut.Node.Propose(ctx, 1, append([]byte{commandMagic}, newer...))
+	require.ErrorIs(t, err, errUnsupportedCommand)
+
+	// the node stays up without applying entries and reports why
+	statusResp, err := sut.RaftClient.Status(ctx, &raftV1.StatusRequest{})
+	require.Nilf(t, err, "status not read: %s", err)
+	require.Contains(t, statusResp.GetApplyError(), errUnsupportedCommand.Error())
+	health, err := grpc_health_v1.NewHealthClient(sut.Client).Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+	require.Nilf(t, err, "health check failed: %s", err)
+	require.Equal(t, grpc_health_v1.HealthCheckResponse_NOT_SERVING, health.GetStatus())
+	_, err = sut.KeyValueV2Client.Put(ctx, &apiV2.PutRequest{Key: "foo", Value: []byte("bar")})
+	require.NotNil(t, err, "put expected to fail while entries aren't applied")
+}
+
+func Test_Service_Metrics(t *testing.T) {
+	proposeC := make(chan string)
+	defer close(proposeC)
+
+	confChangeC := make(chan raftpb.ConfChangeI)
+	defer close(confChangeC)
+
+	clusters := []string{"http://127.0.0.1:9181"}
+	sut := StartTestGrpcServer(1, clusters, proposeC, confChangeC, t.TempDir())
+	defer sut.Server.Stop()
+
+	setValue(t, sut.KeyValueClient, 1)
+
+	metrics, err := serveMetrics("127.0.0.1:9182")
+	require.Nilf(t, err, "metrics not served: %s", err)
+	defer metrics.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	watch, err := sut.KeyValueV2Client.Watch(ctx, &apiV2.WatchRequest{Key: "foo"})
+	require.Nilf(t, err, "watch not started: %s", err)
+	_, err = watch.Recv()
+	require.Nilf(t, err, "watch not created: %s", err)
+
+	// metrics are served on the peer URL and the metrics address alike
+	for _, url := range []string{clusters[0] + "/metrics", "http://127.0.0.1:9182/metrics"} {
+		resp, err := http.Get(url)
+		require.Nilf(t, err, "metrics not scraped: %s", err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.Nilf(t, err, "metrics not read: %s", err)
+		require.Contains(t, string(body), `raftexample_grpc_streams_open{method="/api.v2.KeyValueService/Watch"} 1`)
+	}
+
+	resp, err := http.Get(clusters[0] + "/metrics")
+	require.Nilf(t, err, "metrics not scraped: %s", err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.Nilf(t, err, "metrics not read: %s", err)
+
+	for _, metric := range []string{
+		"raftexample_raft_proposals_total",
+		`raftexample_raft_proposal_duration_seconds_count{result="applied"}`,
+		"raftexample_raft_apply_lag_entries",
+		"raftexample_raft_leader_changes_total",
+		"raftexample_raft_is_leader",
+		`raftexample_grpc_requests_total{code="OK",method="/api.v1.KeyValueService/Set"}`,
+		"etcd_disk_wal_fsync_duration_seconds_count",
+	} {
+		require.Contains(t, string(body), metric)
+	}
+}
+
+func Test_Service_SingleNode_Auth(t *testing.T) {
+	proposeC := make(chan string)
+	defer close(proposeC)
+
+	confChangeC := make(chan raftpb.ConfChangeI)
+	defer close(confChangeC)
+
+	clusters := []string{"http://127.0.0.1:9191"}
+	sut := StartTestGrpcServer(1, clusters, proposeC, confChangeC, t.TempDir())
+	defer sut.Server.Stop()
+
+	setValue(t, sut.KeyValueClient, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := sut.AuthClient.AuthEnable(ctx, &apiV2.AuthEnableRequest{})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err), "auth enabled without root: %s", err)
+
+	// auth names are stored prefixed, they have to fit into a key together
+	userName := strings.Repeat("u", maxKeySize-len(authUserPrefix))
+	_, err = sut.AuthClient.UserAdd(ctx, &apiV2.UserAddRequest{Name: userName + "u", Password: "secret"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err), "unexpected error: %s", err)
+	_, err = sut.AuthClient.UserAdd(ctx, &apiV2.UserAddRequest{Name: userName, Password: "secret"})
+	require.Nilf(t, err, "user not added: %s", err)
+	roleName := strings.Repeat("r", maxKeySize-len(authRolePrefix))
+	_, err = sut.AuthClient.RoleAdd(ctx, &apiV2.RoleAddRequest{Name: roleName + "r"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err), "unexpected error: %s", err)
+	_, err = sut.AuthClient.RoleAdd(ctx, &apiV2.RoleAddRequest{Name: roleName})
+	require.Nilf(t, err, "role not added: %s", err)
+
+	for _, call := range []func() (*apiV2.AuthResponse, error){
+		func() (*apiV2.AuthResponse, error) {
+			return sut.AuthClient.UserAdd(ctx, &apiV2.UserAddRequest{Name: rootUser, Password: "secret"})
+		},
+		func() (*apiV2.AuthResponse, error) {
+			return sut.AuthClient.UserGrantRole(ctx, &apiV2.UserGrantRoleRequest{User: rootUser, Role: rootRole})
+		},
+		func() (*apiV2.AuthResponse, error) {
+			return sut.AuthClient.RoleAdd(ctx, &apiV2.RoleAddRequest{Name: "sensors"})
+		},
+		func() (*apiV2.AuthResponse, error) {
+			return sut.AuthClient.RoleGrantPermission(ctx, &apiV2.RoleGrantPermissionRequest{Name: "sensors", Permission: &apiV2.Permission{
+				Target: &apiV2.Permission_Method{Method: "/api.v2.KeyValueService/*"},
+			}})
+		},
+		func() (*apiV2.AuthResponse, error) {
+			return sut.AuthClient.RoleGrantPermission(ctx, &apiV2.RoleGrantPermissionRequest{Name: "sensors", Permission: &apiV2.Permission{
+				Target: &apiV2.Permission_Method{Method: "/api.v2.LeaseService/*"},
+			}})
+		},
+		func() (*apiV2.AuthResponse, error) {
+			return sut.AuthClient.RoleGrantPermission(ctx, &apiV2.RoleGrantPermissionRequest{Name: "sensors", Permission: &apiV2.Permission{
+				Target: &apiV2.Permission_KeyPrefix{KeyPrefix: "sensors/"},
+				Type:   apiV2.Permission_READWRITE,
+			}})
+		},
+		func() (*apiV2.AuthResponse, error) {
+			return sut.AuthClient.UserAdd(ctx, &apiV2.UserAddRequest{Name: "device", Password: "device-secret"})
+		},
+		func() (*apiV2.AuthResponse, error) {
+			return sut.AuthClient.UserGrantRole(ctx, &apiV2.UserGrantRoleRequest{User: "device", Role: "sensors"})
+		},
+		func() (*apiV2.AuthResponse, error) {
+			return sut.AuthClient.AuthEnable(ctx, &apiV2.AuthEnableRequest{})
+		},
+	} {
+		resp, err := call()
+		require.Nilf(t, err, "auth not changed: %s", err)
+		require.NotZero(t, resp.GetIndex(), "commit index not returned")
+	}
+
+	// anonymous requests are refused, health checks keep working
+	_, err = sut.KeyValueV2Client.Put(ctx, &apiV2.PutRequest{Key: "sensors/1/temp", Value: []byte("20")})
+	require.Equal(t, codes.Unauthenticated, status.Code(err), "unexpected error: %s", err)
+	_, err = grpc_health_v1.NewHealthClient(sut.Client).Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+	require.Nilf(t, err, "health check failed: %s", err)
+	_, err = sut.KeyValueV2Client.Put(withCredentials(ctx, "device", "wrong"), &apiV2.PutRequest{Key: "sensors/1/temp", Value: []byte("20")})
+	require.Equal(t, codes.Unauthenticated, status.Code(err), "unexpected error: %s", err)
+
+	device := withCredentials(ctx, "device", "device-secret")
+	_, err = sut.KeyValueV2Client.Put(device, &apiV2.PutRequest{Key: "sensors/1/temp", Value: []byte("20")})
+	require.Nilf(t, err, "value not put: %s", err)
+	resp, err := sut.KeyValueV2Client.Range(device, &apiV2.RangeRequest{Prefix: "sensors/"})
+	require.Nilf(t, err, "range not read: %s", err)
+	require.Len(t, resp.GetKvs(), 1)
+
+	for _, err := range []error{
+		func() error {
+			_, err := sut.KeyValueV2Client.Put(device, &apiV2.PutRequest{Key: "devices/1", Value: []byte("on")})
+			return err
+		}(),
+		func() error {
+			_, err := sut.KeyValueClient.Get(device, &apiV1.GetValueRequest{})
+			return err
+		}(),
+		func() error {
+			_, err := sut.RaftClient.Remove(device, &raftV1.NodeRequest{Id: 2})
+			return err
+		}(),
+		func() error {
+			_, err := sut.AuthClient.AuthDisable(device, &apiV2.AuthDisableRequest{})
+			return err
+		}(),
+		func() error {
+			stream, err := sut.KeyValueV2Client.Watch(device, &apiV2.WatchRequest{Key: "devices/", Prefix: true})
+			require.Nilf(t, err, "watch not sent: %s", err)
+			_, err = stream.Recv()
+			return err
+		}(),
+	} {
+		require.Equal(t, codes.PermissionDenied, status.Code(err), "unexpected error: %s", err)
+	}
+
+	// leases can only be used by users allowed to write the keys attached to them
+	root := withCredentials(ctx, rootUser, "secret")
+	rootLease, err := sut.LeaseClient.Grant(root, &apiV2.LeaseGrantRequest{Ttl: 60})
+	require.Nilf(t, err, "lease not granted: %s", err)
+	_, err = sut.KeyValueV2Client.Put(root, &apiV2.PutRequest{Key: "devices/1", Value: []byte("on"), Lease: rootLease.GetId()})
+	require.Nilf(t, err, "value not put: %s", err)
+	for _, err := range []error{
+		func() error {
+			_, err := sut.LeaseClient.Revoke(device, &apiV2.LeaseRevokeRequest{Id: rootLease.GetId()})
+			return err
+		}(),
+		func() error {
+			_, err := sut.LeaseClient.KeepAlive(device, &apiV2.LeaseKeepAliveRequest{Id: rootLease.GetId()})
+			return err
+		}(),
+		func() error {
+			_, err := sut.KeyValueV2Client.Put(device, &apiV2.PutRequest{Key: "sensors/1/temp", Value: []byte("21"), Lease: rootLease.GetId()})
+			return err
+		}(),
+	} {
+		require.Equal(t, codes.PermissionDenied, status.Code(err), "unexpected error: %s", err)
+	}
+	_, err = sut.KeyValueV2Client.Get(root, &apiV2.GetRequest{Key: "devices/1"})
+	require.Nilf(t, err, "value of the lease deleted: %s", err)
+
+	deviceLease, err := sut.LeaseClient.Grant(device, &apiV2.LeaseGrantRequest{Ttl: 60})
+	require.Nilf(t, err, "lease not granted: %s", err)
+	_, err = sut.KeyValueV2Client.Put(device, &apiV2.PutRequest{Key: "sensors/2/temp", Value: []byte("18"), Lease: deviceLease.GetId()})
+	require.Nilf(t, err, "value not put: %s", err)
+	_, err = sut.LeaseClient.Revoke(device, &apiV2.LeaseRevokeRequest{Id: deviceLease.GetId()})
+	require.Nilf(t, err, "lease not revoked: %s", err)
+	_, err = sut.LeaseClient.Revoke(root, &apiV2.LeaseRevokeRequest{Id: rootLease.GetId()})
+	require.Nilf(t, err, "lease not revoked: %s", err)
+
+	getResp, err := sut.KeyValueClient.Get(root, &apiV1.GetValueRequest{})
+	require.Nilf(t, err, "value not read: %s", err)
+	require.Equal(t, uint32(1), getResp.GetValue())
+	_, err = sut.KeyValueV2Client.Get(root, &apiV2.GetRequest{Key: authUserPrefix + rootUser})
+	require.Equal(t, codes.InvalidArgument, status.Code(err), "reserved key read: %s", err)
+	_, err = sut.AuthClient.UserDelete(root, &apiV2.UserDeleteRequest{Name: rootUser})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err), "root deleted while auth is enabled: %s", err)
+	_, err = sut.AuthClient.AuthDisable(root, &apiV2.AuthDisableRequest{})
+	require.Nilf(t, err, "auth not disabled: %s", err)
+	assertValueEquals(t, sut.KeyValueClient, 1)
+}
+
+// withCredentials attaches credentials of the user to requests of the context
+func withCredentials(ctx context.Context, name, password string) context.Context {
+	creds := base64.StdEncoding.EncodeToString([]byte(name + ":" + password))
+	return metadata.AppendToOutgoingContext(ctx, authHeader, "Basic "+creds)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"go.etcd.io/etcd/raft/v3"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+// shutdown prepares the node to be stopped: new proposals are refused and the ones in
+// flight drained, leadership is handed over to the most up-to-date follower if this
+// node leads, and a final snapshot is taken. Draining and leadership transfer give up
+// once the context is done, the snapshot is taken anyway. The node is stopped
+// afterwards by closing proposeC. All steps are done, the first failure is returned.
+func (rc *raftNode) shutdown(ctx context.Context) (err error) {
+	fail := func(op string, cause error) {
+		log.Printf("raftexample: cannot %s on shutdown (%v)", op, cause)
+		if err == nil {
+			err = &raftError{op: op, err: cause}
+		}
+	}
+
+	if derr := rc.drain(ctx); derr != nil {
+		fail("drain proposals", derr)
+	}
+	if rc.isLeader() {
+		lead, terr := rc.transferLeadership(ctx, raft.None)
+		switch {
+		case errors.Is(terr, errInvalidTransferee):
+			log.Printf("raftexample: no follower to hand leadership over to")
+		case terr != nil:
+			fail("transfer leadership", terr)
+		default:
+			log.Printf("raftexample: leadership handed over to %d", lead)
+		}
+	}
+	if serr := rc.requestSnapshot(); serr != nil {
+		fail("take final snapshot", serr)
+	}
+	return err
+}
+
+// drain refuses new proposals and waits for the ones in flight to finish
+func (rc *raftNode) drain(ctx context.Context) error {
+	rc.mu.Lock()
+	rc.draining = true
+	rc.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		rc.inflight.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// isDraining tells if the node refuses proposals as it shuts down
+func (rc *raftNode) isDraining() bool {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+	return rc.draining
+}
+
+// requestSnapshot has the raft loop take a snapshot of all entries applied so far
+func (rc *raftNode) requestSnapshot() error {
+	errc := make(chan error, 1)
+	select {
+	case rc.snapshotC <- errc:
+	case <-rc.stoppedc:
+		return errStopped
+	}
+	return <-errc
+}
+
+// stopped is closed once the raft loop returned and the WAL is closed
+func (rc *raftNode) stopped() <-chan struct{} {
+	return rc.stoppedc
+}
+
+// gracefulShutdown stops the node in order: writes are refused and proposals in flight
+// drained, leadership is handed over, a final snapshot is taken, the gRPC server stops
+// once requests in flight are served, and the raft node stops, closing the WAL. Steps
+// still running when the context is done are cut short, in-flight requests get
+// canceled then. The final snapshot and closing the WAL are always done.
+func gracefulShutdown(ctx context.Context, log *zap.Logger, server *grpc.Server, node *raftNode, store *kvstore, proposeC chan<- string) {
+	log.Info("Shutting down...")
+	if err := node.shutdown(ctx); err != nil {
+		log.Warn("Node not shut down gracefully", zap.Error(err))
+	}
+
+	// watch streams would keep the gRPC server running until the timeout
+	store.CancelWatches(errShuttingDown)
+	stopGRPC(ctx, server)
+
+	close(proposeC)
+	<-node.stopped()
+	log.Info("Node stopped")
+}
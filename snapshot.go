@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+// Snapshots are written as a header followed by length-prefixed lease and key records
+// and a trailing checksum:
+//
+//	header:   magic "RGSN" | version uint32 | applied index uint64 | leases uint64 | entries uint64
+//	lease:    id varint | TTL varint
+//	entry:    key length uvarint | key | value length uvarint | value | mod index uvarint | lease varint
+//	trailer:  CRC-32C of all preceding bytes uint32
+//
+// Fixed size integers are big endian.
+const (
+	snapshotMagic   = "RGSN"
+	snapshotVersion = 1
+
+	// maxSnapshotFieldSize bounds keys and values read back, so a corrupted length
+	// can't make a device allocate more memory than it has
+	maxSnapshotFieldSize = 64 << 20
+)
+
+var (
+	errSnapshotChecksum = errors.New("snapshot checksum mismatch")
+	crcTable            = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// snapshotHeader describes the snapshot records following it
+type snapshotHeader struct {
+	Version      uint32
+	AppliedIndex uint64
+	Leases       uint64
+	Entries      uint64
+}
+
+// snapshotView returns the content snapshots are written from. Pairs are cloned
+// lazily, nodes of the tree are shared until either side changes them, and only the
+// few leases get copied. It has to be called with the backend write lock held.
+func (b *memBackend) snapshotView() *memBackend {
+	view := &memBackend{
+		items: b.items.Clone(),
+		ttls:  make(map[int64]int64, len(b.ttls)),
+		index: b.index,
+	}
+	for id, ttl := range b.ttls {
+		view.ttls[id] = ttl
+	}
+	return view
+}
+
+// writeSnapshot writes the content record by record. It's called on a view of the
+// backend nobody else uses, so no lock is held.
+func (b *memBackend) writeSnapshot(w io.Writer) error {
+	h := crc32.New(crcTable)
+	bw := bufio.NewWriter(io.MultiWriter(w, h))
+	header := snapshotHeader{
+		Version:      snapshotVersion,
+		AppliedIndex: b.index,
+		Leases:       uint64(len(b.ttls)),
+		Entries:      uint64(b.items.Len()),
+	}
+	bw.WriteString(snapshotMagic)
+	if err := binary.Write(bw, binary.BigEndian, header); err != nil {
+		return err
+	}
+
+	buf := make([]byte, binary.MaxVarintLen64)
+	putUvarint := func(v uint64) { bw.Write(buf[:binary.PutUvarint(buf, v)]) }
+	putVarint := func(v int64) { bw.Write(buf[:binary.PutVarint(buf, v)]) }
+
+	for id, ttl := range b.ttls {
+		putVarint(id)
+		putVarint(ttl)
+	}
+	b.items.Ascend(func(item memItem) bool {
+		putUvarint(uint64(len(item.key)))
+		bw.WriteString(item.key)
+		putUvarint(uint64(len(item.val)))
+		bw.WriteString(item.val)
+		putUvarint(item.meta.ModIndex)
+		putVarint(item.meta.Lease)
+		return true
+	})
+
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.BigEndian, h.Sum32())
+}
+
+// readSnapshot writes the snapshot record by record to the transaction and fails
+// if the checksum doesn't match, so it's meant to be run by backend.reset.
+func readSnapshot(r io.Reader, tx backendTx) (snapshotHeader, error) {
+	sr := &snapshotReader{r: bufio.NewReader(r), h: crc32.New(crcTable)}
+
+	var header snapshotHeader
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(sr, magic); err != nil {
+		return header, err
+	}
+	if string(magic) != snapshotMagic {
+		return header, fmt.Errorf("not a snapshot, unexpected magic %q", magic)
+	}
+	if err := binary.Read(sr, binary.BigEndian, &header); err != nil {
+		return header, err
+	}
+	if header.Version > snapshotVersion {
+		return header, fmt.Errorf("unsupported snapshot version %d", header.Version)
+	}
+
+	for i := uint64(0); i < header.Leases; i++ {
+		id, err := binary.ReadVarint(sr)
+		if err != nil {
+			return header, err
+		}
+		ttl, err := binary.ReadVarint(sr)
+		if err != nil {
+			return header, err
+		}
+		tx.putLease(id, ttl)
+	}
+
+	for i := uint64(0); i < header.Entries; i++ {
+		k, err := sr.readString()
+		if err != nil {
+			return header, err
+		}
+		v, err := sr.readString()
+		if err != nil {
+			return header, err
+		}
+		modIndex, err := binary.ReadUvarint(sr)
+		if err != nil {
+			return header, err
+		}
+		leaseID, err := binary.ReadVarint(sr)
+		if err != nil {
+			return header, err
+		}
+		tx.put(k, v, keyMeta{ModIndex: modIndex, Lease: leaseID})
+	}
+
+	sum := sr.h.Sum32()
+	var want uint32
+	if err := binary.Read(sr.r, binary.BigEndian, &want); err != nil {
+		return header, err
+	}
+	if sum != want {
+		return header, errSnapshotChecksum
+	}
+	return header, nil
+}
+
+// snapshotReader checksums all bytes read through it
+type snapshotReader struct {
+	r *bufio.Reader
+	h hash.Hash32
+}
+
+func (r *snapshotReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.h.Write(p[:n])
+	return n, err
+}
+
+func (r *snapshotReader) ReadByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err == nil {
+		r.h.Write([]byte{b})
+	}
+	return b, err
+}
+
+func (r *snapshotReader) readString() (string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+	if n > maxSnapshotFieldSize {
+		return "", fmt.Errorf("snapshot field of %d bytes exceeds the limit", n)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
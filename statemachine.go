@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"time"
+
+	"go.etcd.io/etcd/raft/v3/raftpb"
+)
+
+// Entry is a committed log entry handed over to the state machine
+type Entry struct {
+	Index uint64
+	Data  []byte
+}
+
+// Result of applying a single entry. Proposals waiting for the entry get it back
+// through raftNode.Propose.
+type Result struct {
+	ID    uint64 // request ID of the proposal, 0 if nobody waits for it
+	Index uint64
+	Value interface{}
+	Err   error
+}
+
+// StateMachine is the application state replicated by raftNode. Its methods are
+// called from a single goroutine in log order.
+type StateMachine interface {
+	// Apply applies committed entries and returns results of the ones proposals
+	// wait for. An error means the state machine can't go on, like for entries of a
+	// newer version. The node keeps replicating entries without applying them then,
+	// until it's restarted.
+	Apply(entries []Entry) ([]Result, error)
+	// Snapshot captures the state once all entries handed over have been applied and
+	// returns a function writing it. The function is called once, off the goroutine
+	// applying entries, and mustn't write changes of entries applied meanwhile.
+	Snapshot() (func(w io.Writer) error, error)
+	// Restore replaces the state with the snapshot taken at the raft log index.
+	Restore(r io.Reader, index uint64) error
+	// AppliedIndex returns the raft log index entries have been applied up to. State
+	// machines persisting their state report it after a restart, they aren't restored
+	// from older snapshots then and have to skip entries they already applied.
+	AppliedIndex() uint64
+}
+
+// Proposer replicates data through raft and waits for the state machine to apply it
+type Proposer interface {
+	Propose(ctx context.Context, id uint64, data []byte) (Result, error)
+}
+
+// applyBatch is handed over from the raft loop to the state machine, it carries
+// either committed entries or a snapshot to restore
+type applyBatch struct {
+	entries    []Entry
+	snapshot   *raftpb.Snapshot
+	index      uint64 // index the state machine is at once the batch has been applied
+	applyDoneC chan struct{}
+}
+
+// applyLoop hands committed entries and snapshots over to the state machine in
+// log order, until the raft node stops. Once the state machine failed the error is
+// reported by status and later batches are dropped.
+func (rc *raftNode) applyLoop() {
+	defer close(rc.donec)
+	var failed bool
+	for b := range rc.applyC {
+		if !failed {
+			if err := rc.apply(b); err != nil {
+				failed = true
+				log.Printf("raftexample: stopped applying committed entries (%v)", err)
+				rc.mu.Lock()
+				rc.applyErr = err
+				rc.mu.Unlock()
+				close(rc.applyFailedC)
+			}
+		}
+		close(b.applyDoneC)
+	}
+}
+
+// applyError returns the failure of the state machine, nil while it applies entries
+func (rc *raftNode) applyError() error {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+	return rc.applyErr
+}
+
+// apply hands the batch over to the state machine
+func (rc *raftNode) apply(b *applyBatch) error {
+	if b.snapshot != nil {
+		log.Printf("loading snapshot at term %d and index %d", b.snapshot.Metadata.Term, b.snapshot.Metadata.Index)
+		if err := rc.restore(*b.snapshot); err != nil {
+			return &raftError{op: "restore state machine from snapshot", err: err}
+		}
+	}
+	if len(b.entries) > 0 {
+		results, err := rc.sm.Apply(b.entries)
+		if err != nil {
+			return &raftError{op: "apply committed entries", err: err}
+		}
+		for _, r := range results {
+			if r.ID != 0 {
+				rc.proposeWait.Trigger(r.ID, r)
+			}
+		}
+	}
+	rc.appliedWait.Trigger(b.index)
+	return nil
+}
+
+// Propose replicates the data and waits until the state machine applied it and
+// returned a result with the request ID. Proposals are refused with errShuttingDown
+// once the node started shutting down.
+func (rc *raftNode) Propose(ctx context.Context, id uint64, data []byte) (Result, error) {
+	proposalsTotal.Inc()
+	rc.mu.Lock()
+	if rc.draining {
+		rc.mu.Unlock()
+		proposalsFailed.Inc()
+		return Result{}, errShuttingDown
+	}
+	rc.inflight.Add(1)
+	rc.mu.Unlock()
+	defer rc.inflight.Done()
+
+	start := time.Now()
+	fail := func(err error) (Result, error) {
+		proposalsFailed.Inc()
+		proposalDurationSec.WithLabelValues("failed").Observe(time.Since(start).Seconds())
+		return Result{}, err
+	}
+	ch := rc.proposeWait.Register(id)
+	if err := rc.propose(ctx, data); err != nil {
+		rc.proposeWait.Trigger(id, nil)
+		return fail(err)
+	}
+
+	select {
+	case x := <-ch:
+		r, ok := x.(Result)
+		if !ok {
+			return fail(errStopped)
+		}
+		proposalDurationSec.WithLabelValues("applied").Observe(time.Since(start).Seconds())
+		return r, r.Err
+	case <-ctx.Done():
+		rc.proposeWait.Trigger(id, nil)
+		return fail(ctx.Err())
+	case <-rc.applyFailedC:
+		rc.proposeWait.Trigger(id, nil)
+		return fail(rc.applyError())
+	case <-rc.donec:
+		return fail(errStopped)
+	}
+}
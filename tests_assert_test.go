@@ -25,3 +25,20 @@ func assertValueEquals(t *testing.T, client apiV1.KeyValueServiceClient, wantVal
 	require.Nilf(t, err, "value not read: %s", err)
 	require.Equal(t, wantValue, getValueResp.GetValue(), "value not read")
 }
+
+func setValue(t *testing.T, client apiV1.KeyValueServiceClient, value uint32) *apiV1.SetValueResponse {
+	var setValueResp *apiV1.SetValueResponse
+	var err error
+	for i := 0; i < 50; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		setValueResp, err = client.Set(ctx, &apiV1.SetValueRequest{Value: value})
+		cancel()
+		if err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	require.Nilf(t, err, "value not set: %s", err)
+	require.Truef(t, setValueResp.GetOk(), "value not set")
+	return setValueResp
+}
@@ -15,29 +15,54 @@ import (
 	"google.golang.org/grpc/credentials/insecure"
 
 	apiV1 "github/m-wrona/raft-go/model/api/v1"
+	apiV2 "github/m-wrona/raft-go/model/api/v2"
 	raftV1 "github/m-wrona/raft-go/model/raft/v1"
 )
 
 type TestServer struct {
-	Server         *grpc.Server
-	Client         *grpc.ClientConn
-	RaftClient     raftV1.RaftServiceClient
-	KeyValueClient apiV1.KeyValueServiceClient
+	Node             *raftNode
+	Url              string
+	Server           *grpc.Server
+	Client           *grpc.ClientConn
+	RaftClient       raftV1.RaftServiceClient
+	KeyValueClient   apiV1.KeyValueServiceClient
+	KeyValueV2Client apiV2.KeyValueServiceClient
+	LeaseClient      apiV2.LeaseServiceClient
+	AuthClient       apiV2.AuthServiceClient
 }
 
-func StartTestGrpcServer(id int, clusters []string, proposeC chan string, confChangeC chan raftpb.ConfChange, dirPath string) *TestServer {
-	var kvs *kvstore
-	getSnapshot := func() ([]byte, error) { return kvs.getSnapshot() }
+func StartTestGrpcServer(id int, clusters []string, proposeC chan string, confChangeC chan raftpb.ConfChangeI, dirPath string) *TestServer {
+	return StartTestGrpcServerWithBackend(id, clusters, proposeC, confChangeC, dirPath, newMemoryBackend(dirPath))
+}
+
+func StartTestGrpcServerWithBackend(id int, clusters []string, proposeC chan string, confChangeC chan raftpb.ConfChangeI, dirPath string, b backend) *TestServer {
 	join := id > 1
-	commitC, errorC, snapshotterReady := newRaftNode(id, clusters, join, getSnapshot, proposeC, confChangeC, dirPath)
-	kvs = newKVStore(<-snapshotterReady, proposeC, commitC, errorC)
+	kvs, err := newKVStore(id, b)
+	if err != nil {
+		panic(fmt.Errorf("key-value store error: %s", err))
+	}
+	node, errorC, err := newRaftNode(id, clusters, join, defaultRaftConfig(), kvs, proposeC, confChangeC, dirPath)
+	if err != nil {
+		panic(fmt.Errorf("raft node error: %s", err))
+	}
+	kvs.proposer = node
+	go kvs.expireLeases(node.isLeader, node.done())
+	go func() {
+		for range errorC {
+			// errors are left to the tests
+		}
+	}()
 
 	time.Sleep(500 * time.Millisecond)
 
 	serverUrl := RandomServerUrl()
 	log, _ := zap.NewDevelopment()
-	server := grpc.NewServer()
-	newController(server, log, kvs, confChangeC)
+	auth := newAuthorizer(log, kvs)
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(metricsUnaryInterceptor, auth.UnaryInterceptor, newForwarder(log, node, insecure.NewCredentials()).UnaryInterceptor),
+		grpc.ChainStreamInterceptor(metricsStreamInterceptor, auth.StreamInterceptor),
+	)
+	newController(server, log, kvs, node)
 
 	go func() {
 		log.Debug("Starting test GRPC server...", zap.String("url", serverUrl))
@@ -52,7 +77,6 @@ func StartTestGrpcServer(id int, clusters []string, proposeC chan string, confCh
 	}()
 
 	var conn *grpc.ClientConn
-	var err error
 	for i := 0; i < 60; i++ {
 		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
 		conn, err = grpc.DialContext(ctx, serverUrl, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock())
@@ -67,15 +91,21 @@ func StartTestGrpcServer(id int, clusters []string, proposeC chan string, confCh
 	}
 
 	return &TestServer{
-		Server:         server,
-		Client:         conn,
-		RaftClient:     raftV1.NewRaftServiceClient(conn),
-		KeyValueClient: apiV1.NewKeyValueServiceClient(conn),
+		Node:             node,
+		Url:              serverUrl,
+		Server:           server,
+		Client:           conn,
+		RaftClient:       raftV1.NewRaftServiceClient(conn),
+		KeyValueClient:   apiV1.NewKeyValueServiceClient(conn),
+		KeyValueV2Client: apiV2.NewKeyValueServiceClient(conn),
+		LeaseClient:      apiV2.NewLeaseServiceClient(conn),
+		AuthClient:       apiV2.NewAuthServiceClient(conn),
 	}
 }
 
 func RandomPort() int {
 	listen := RandomListener("tcp")
+	defer listen.Close()
 	idx := strings.LastIndex(listen.Addr().String(), ":")
 	p := listen.Addr().String()[idx+1:]
 	if port, err := strconv.Atoi(p); err != nil {
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/etcd/raft/v3/raftpb"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// testCA signs certificates of test nodes
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	file string
+}
+
+func newTestCA(t *testing.T, dir string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "raft-go test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	ca := &testCA{cert: cert, key: key, file: filepath.Join(dir, "ca.pem")}
+	writePEM(t, ca.file, "CERTIFICATE", der)
+	return ca
+}
+
+// issue writes a certificate of 127.0.0.1 for servers and clients with the serial
+// and its key to the files.
+func (ca *testCA) issue(t *testing.T, serial int64, certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "raft-go test node"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600)
+	require.NoError(t, err)
+}
+
+// testTLSConfig returns the raft config of nodes sharing a certificate signed by
+// the CA
+func testTLSConfig(t *testing.T) (RaftConfig, *testCA) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+	cfg := defaultRaftConfig()
+	cfg.CertFile = filepath.Join(dir, "node.pem")
+	cfg.KeyFile = filepath.Join(dir, "node-key.pem")
+	cfg.TrustedCAFile = ca.file
+	ca.issue(t, 2, cfg.CertFile, cfg.KeyFile)
+	return cfg, ca
+}
+
+// Test_TLS_PeerCluster tests peers replicate entries over mutual TLS.
+func Test_TLS_PeerCluster(t *testing.T) {
+	cfg, _ := testTLSConfig(t)
+	clus := newCluster(3, t.TempDir(), cfg)
+	defer clus.closeNoErrors(t)
+
+	go func() { clus.proposeC[0] <- "foo" }()
+	for i := range clus.peers {
+		c := <-clus.commitC[i]
+		close(c.applyDoneC)
+		require.Equal(t, []string{"foo"}, c.data)
+	}
+}
+
+func Test_TLS_PeerClientCertAndReload(t *testing.T) {
+	cfg, ca := testTLSConfig(t)
+	peerURL := "https://127.0.0.1:10031"
+
+	proposeC := make(chan string)
+	defer close(proposeC)
+	confChangeC := make(chan raftpb.ConfChangeI)
+	defer close(confChangeC)
+	_, errorC, err := newRaftNode(1, []string{peerURL}, false, cfg, newTestStateMachine(), proposeC, confChangeC, t.TempDir())
+	require.NoError(t, err)
+	go func() {
+		for range errorC {
+		}
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	serverSerial := func(clientCert bool) (int64, error) {
+		tlsCfg := &tls.Config{RootCAs: roots}
+		if clientCert {
+			cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+			require.NoError(t, err)
+			tlsCfg.Certificates = []tls.Certificate{cert}
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsCfg}}
+		defer client.CloseIdleConnections()
+		resp, err := client.Get(peerURL + "/metrics")
+		if err != nil {
+			return 0, err
+		}
+		defer resp.Body.Close()
+		return resp.TLS.PeerCertificates[0].SerialNumber.Int64(), nil
+	}
+
+	_, err = serverSerial(false)
+	require.Error(t, err, "peer accepted a client without certificate")
+
+	serial, err := serverSerial(true)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), serial)
+
+	// renewed certificates are served without a restart
+	ca.issue(t, 3, cfg.CertFile, cfg.KeyFile)
+	serial, err = serverSerial(true)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), serial)
+}
+
+func Test_TLS_GRPC(t *testing.T) {
+	raftCfg, ca := testTLSConfig(t)
+	cfg := defaultConfig()
+	cfg.GRPCCertFile, cfg.GRPCKeyFile, cfg.GRPCTrustedCAFile = raftCfg.CertFile, raftCfg.KeyFile, raftCfg.TrustedCAFile
+
+	server, err := NewGRPCServer(&cfg, zap.NewNop())
+	require.NoError(t, err)
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go server.Serve(ln)
+	defer server.Stop()
+
+	check := func(creds credentials.TransportCredentials) error {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		conn, err := grpc.DialContext(ctx, ln.Addr().String(), grpc.WithTransportCredentials(creds))
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+		return err
+	}
+
+	// nodes dial each other with their own certificate
+	creds, err := grpcDialCredentials(cfg.grpcTLSInfo())
+	require.NoError(t, err)
+	require.NoError(t, check(creds), "client with certificate refused")
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	require.Error(t, check(credentials.NewTLS(&tls.Config{RootCAs: roots})), "client without certificate accepted")
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+)
+
+type compareTarget uint8
+
+const (
+	compareValue compareTarget = iota
+	compareModIndex
+)
+
+type compareResult uint8
+
+const (
+	compareEqual compareResult = iota
+	compareNotEqual
+	compareGreater
+	compareLess
+)
+
+// compare is a condition on the current state of a key. A missing key has zero
+// modification index and fails all compares of its value.
+type compare struct {
+	Key      string
+	Target   compareTarget
+	Result   compareResult
+	Val      string // compared for compareValue
+	ModIndex uint64 // compared for compareModIndex
+}
+
+// txnOp is an update executed by a transaction, either opPut or opDelete
+type txnOp struct {
+	Op  op
+	Key string
+	Val string
+}
+
+// txn applies success ops if all compares hold or failure ops otherwise
+type txn struct {
+	Compares []compare
+	Success  []txnOp
+	Failure  []txnOp
+}
+
+// Txn replicates the transaction through raft and blocks until it has been applied.
+// It returns the raft log index the transaction was committed at and whether all of
+// its compares held.
+func (s *kvstore) Txn(ctx context.Context, t txn) (uint64, bool, error) {
+	result, err := s.propose(ctx, command{Op: opTxn, Txn: &t})
+	return result.index, result.succeeded, err
+}
+
+// checkKeySizes verifies keys put by the transaction fit into backends
+func (t *txn) checkKeySizes() error {
+	if t == nil {
+		return nil
+	}
+	for _, ops := range [][]txnOp{t.Success, t.Failure} {
+		for _, o := range ops {
+			if o.Op != opPut {
+				continue
+			}
+			if err := checkKeySize(o.Key, 0); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// applyTxn evaluates and applies the transaction atomically. It has to be called
+// with the store lock held.
+func (s *kvstore) applyTxn(tx backendTx, index uint64, t *txn) ([]event, bool) {
+	if t == nil {
+		log.Printf("raftexample: ignoring transaction without body at index %d", index)
+		return nil, false
+	}
+
+	succeeded := true
+	for _, c := range t.Compares {
+		if !holds(tx, c) {
+			succeeded = false
+			break
+		}
+	}
+	ops := t.Success
+	if !succeeded {
+		ops = t.Failure
+	}
+
+	var events []event
+	for _, o := range ops {
+		switch o.Op {
+		case opPut:
+			events = append(events, s.put(tx, index, o.Key, o.Val, 0))
+		case opDelete:
+			// deleting a missing key doesn't fail the transaction
+			if ev, ok := s.delete(tx, index, o.Key); ok {
+				events = append(events, ev)
+			}
+		default:
+			log.Printf("raftexample: ignoring unknown transaction op %d for key %q", o.Op, o.Key)
+		}
+	}
+	return events, succeeded
+}
+
+func holds(tx backendTx, c compare) bool {
+	v, meta, ok := tx.get(c.Key)
+	var cmp int
+	switch c.Target {
+	case compareValue:
+		if !ok {
+			return false
+		}
+		cmp = strings.Compare(v, c.Val)
+	case compareModIndex:
+		cmp = compareUints(meta.ModIndex, c.ModIndex)
+	default:
+		return false
+	}
+
+	switch c.Result {
+	case compareEqual:
+		return cmp == 0
+	case compareNotEqual:
+		return cmp != 0
+	case compareGreater:
+		return cmp > 0
+	case compareLess:
+		return cmp < 0
+	default:
+		return false
+	}
+}
+
+func compareUints(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
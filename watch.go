@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// watcherBufferSize is the number of events a watcher may lag behind before it's canceled
+	watcherBufferSize = 128
+
+	// historyKeyPrefix reserves the part of the key space applied changes are kept in
+	// for watchers resuming from an index. Changes are kept by the index they got
+	// applied at, next to the index of the first change kept. Like any other update
+	// history is replicated, snapshotted and restored with the store.
+	historyKeyPrefix   = "\x00history/"
+	historyStartKey    = historyKeyPrefix + "start"
+	historyEventPrefix = historyKeyPrefix + "event/"
+)
+
+var (
+	errCompacted      = errors.New("watch start index has been compacted")
+	errWatcherTooSlow = errors.New("watcher fell behind applied changes")
+	errBadHistory     = errors.New("malformed watch history")
+)
+
+// event is a change of a single key applied to the store
+type event struct {
+	Op    op
+	Key   string
+	Val   string
+	Index uint64 // raft log index of the change
+}
+
+// watcher receives events of a key, or of all keys with a prefix
+type watcher struct {
+	key    string
+	prefix bool
+	start  uint64 // events at lower indexes are skipped
+
+	eventC chan event
+	err    error // reason of cancellation, set before eventC is closed
+}
+
+func (w *watcher) matches(ev event) bool {
+	if ev.Index < w.start {
+		return false
+	}
+	if w.prefix {
+		return strings.HasPrefix(ev.Key, w.key)
+	}
+	return ev.Key == w.key
+}
+
+// Watch registers a watcher of the key, or of all keys with the prefix. Changes applied
+// at or after the start index that are still kept in history are returned so they can be
+// delivered before the ones sent over the watcher channel. A zero start index only
+// watches changes applied after registration. errCompacted is returned if changes at the
+// start index have already been dropped from history.
+func (s *kvstore) Watch(key string, prefix bool, start uint64) (*watcher, []event, error) {
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+
+	w := &watcher{key: key, prefix: prefix, start: start, eventC: make(chan event, watcherBufferSize)}
+
+	var backlog []event
+	if start != 0 {
+		var err error
+		if backlog, err = s.history(w); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if s.watchers == nil {
+		s.watchers = make(map[*watcher]struct{})
+	}
+	s.watchers[w] = struct{}{}
+	return w, backlog, nil
+}
+
+// history returns the changes kept for the watcher from its start index up to the last
+// index delivered to watchers. It has to be called with the watch lock held.
+func (s *kvstore) history(w *watcher) ([]event, error) {
+	var backlog []event
+	err := s.backend.view(func(tx backendTx) error {
+		if w.start < historyStart(tx) {
+			return errCompacted
+		}
+		if w.start > s.notified {
+			return nil
+		}
+		var err error
+		tx.ascend(historyEventKey(w.start), historyEventKey(s.notified+1), func(key, val string, _ keyMeta) bool {
+			var index uint64
+			var events []event
+			if index, err = strconv.ParseUint(strings.TrimPrefix(key, historyEventPrefix), 16, 64); err != nil {
+				err = fmt.Errorf("%w: key %q", errBadHistory, key)
+				return false
+			}
+			if events, err = decodeEvents(index, val); err != nil {
+				return false
+			}
+			for _, ev := range events {
+				if w.matches(ev) {
+					backlog = append(backlog, ev)
+				}
+			}
+			return true
+		})
+		return err
+	})
+	return backlog, err
+}
+
+// CancelWatch stops delivering events to the watcher.
+func (s *kvstore) CancelWatch(w *watcher) {
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+	s.cancelWatcher(w, nil)
+}
+
+// CancelWatches stops delivering events to all watchers, they get the error
+func (s *kvstore) CancelWatches(err error) {
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+	for w := range s.watchers {
+		s.cancelWatcher(w, err)
+	}
+}
+
+func (s *kvstore) cancelWatcher(w *watcher, err error) {
+	if _, ok := s.watchers[w]; !ok {
+		return
+	}
+	delete(s.watchers, w)
+	w.err = err
+	close(w.eventC)
+}
+
+// notify delivers changes applied up to the index to matching watchers.
+func (s *kvstore) notify(index uint64, events []event) {
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+
+	s.notified = index
+	for w := range s.watchers {
+		for _, ev := range events {
+			if !w.matches(ev) {
+				continue
+			}
+			select {
+			case w.eventC <- ev:
+			default:
+				s.cancelWatcher(w, errWatcherTooSlow)
+			}
+			if w.err != nil {
+				break
+			}
+		}
+	}
+}
+
+// resetHistory cancels watchers after the store got replaced by a snapshot taken at the
+// index, changes included in the snapshot can't be delivered. Watchers may resume from
+// the history the snapshot kept.
+func (s *kvstore) resetHistory(index uint64) {
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+
+	s.notified = index
+	for w := range s.watchers {
+		s.cancelWatcher(w, errCompacted)
+	}
+}
+
+// initHistory starts history after the index if the backend doesn't keep it yet, like
+// stores and snapshots written before history was kept.
+func (s *kvstore) initHistory(index uint64) error {
+	return s.backend.update(index, func(tx backendTx) error {
+		if _, _, ok := tx.get(historyStartKey); !ok {
+			tx.put(historyStartKey, strconv.FormatUint(index+1, 10), keyMeta{ModIndex: index})
+		}
+		return nil
+	})
+}
+
+// recordHistory keeps the changes applied at the index.
+func recordHistory(tx backendTx, index uint64, events []event) {
+	if len(events) == 0 {
+		return
+	}
+	tx.put(historyEventKey(index), encodeEvents(events), keyMeta{ModIndex: index})
+}
+
+// compactHistory drops changes the raft log doesn't keep to catch up slow followers once
+// the entry at the index got applied, watchers can't resume from them anymore. It caps
+// history to the last catchUpEntries indexes.
+func (s *kvstore) compactHistory(tx backendTx, index uint64) {
+	if index < s.catchUpEntries {
+		return
+	}
+	first := index - s.catchUpEntries + 1
+	start := historyStart(tx)
+	if first <= start {
+		return
+	}
+	var keys []string
+	tx.ascend(historyEventKey(start), historyEventKey(first), func(key, _ string, _ keyMeta) bool {
+		keys = append(keys, key)
+		return true
+	})
+	for _, key := range keys {
+		tx.delete(key)
+	}
+	tx.put(historyStartKey, strconv.FormatUint(first, 10), keyMeta{ModIndex: index})
+}
+
+// historyStart returns the index of the first change kept in history.
+func historyStart(tx backendTx) uint64 {
+	val, _, _ := tx.get(historyStartKey)
+	start, _ := strconv.ParseUint(val, 10, 64)
+	return start
+}
+
+func historyEventKey(index uint64) string {
+	return fmt.Sprintf("%s%016x", historyEventPrefix, index)
+}
+
+// encodeEvents encodes changes applied at a single index as records of the op
+// followed by the uvarint length prefixed key and value.
+func encodeEvents(events []event) string {
+	var b []byte
+	for _, ev := range events {
+		b = append(b, byte(ev.Op))
+		b = binary.AppendUvarint(b, uint64(len(ev.Key)))
+		b = append(b, ev.Key...)
+		b = binary.AppendUvarint(b, uint64(len(ev.Val)))
+		b = append(b, ev.Val...)
+	}
+	return string(b)
+}
+
+func decodeEvents(index uint64, data string) ([]event, error) {
+	var events []event
+	for len(data) > 0 {
+		ev := event{Op: op(data[0]), Index: index}
+		var ok bool
+		if ev.Key, data, ok = readHistoryField(data[1:]); !ok {
+			return nil, fmt.Errorf("%w: index %d", errBadHistory, index)
+		}
+		if ev.Val, data, ok = readHistoryField(data); !ok {
+			return nil, fmt.Errorf("%w: index %d", errBadHistory, index)
+		}
+		events = append(events, ev)
+	}
+	return events, nil
+}
+
+// readHistoryField reads a uvarint length prefixed field and returns the rest of data
+func readHistoryField(data string) (string, string, bool) {
+	head := data
+	if len(head) > binary.MaxVarintLen64 {
+		head = head[:binary.MaxVarintLen64]
+	}
+	n, size := binary.Uvarint([]byte(head))
+	if size <= 0 || n > uint64(len(data)-size) {
+		return "", "", false
+	}
+	end := size + int(n)
+	return data[size:end], data[end:], true
+}